@@ -178,7 +178,7 @@ func (c *Cluster) leave(me serf.MemberEvent) {
 		if p, ok := c.Peers.LoadAndDelete(member.Name); ok {
 			p.(*peer).stop()
 			c.sessions.del(member.Name)
-			_ = c.topicStore.UnsubscribeAll(member.Name)
+			_, _ = c.topicStore.UnsubscribeAll(member.Name)
 			log.Infof("cluster: left %s %s <- %s", member.Name, member.Addr, config.Cfg.NodeName)
 		}
 	}
@@ -265,6 +265,11 @@ func (c *Cluster) SyncHandler(s *session, e *Event) {
 			c.topicStore.Print()
 		}
 	}
+
+	// unsubscribe
+	if unsub := e.GetUnsubscribe(); unsub != nil {
+		_, _ = c.topicStore.Unsubscribe(s.nodeName, unsub.Topic)
+	}
 }
 
 func (c *Cluster) Subscribe(cid, topic string) {
@@ -278,3 +283,15 @@ func (c *Cluster) Subscribe(cid, topic string) {
 		return true
 	})
 }
+
+func (c *Cluster) Unsubscribe(cid, topic string) {
+	c.Peers.Range(func(_, v any) bool {
+		p := v.(*peer)
+		p.queue.push(&Event{
+			Event: &Event_Unsubscribe{Unsubscribe: &Unsubscribe{
+				Topic: topic,
+			}},
+		})
+		return true
+	})
+}
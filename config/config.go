@@ -13,15 +13,27 @@ import (
 )
 
 type listener struct {
-	Enable        bool
-	Host          string
-	Port          int
-	Addr          string
-	ProxyProtocol bool `toml:"proxy_protocol"`
-	Path          string
-	CACert        string
-	TLSCert       string
-	TLSKey        string
+	Enable         bool
+	Host           string
+	Port           int
+	Addr           string
+	ProxyProtocol  bool `toml:"proxy_protocol"`
+	Path           string
+	CACert         string
+	TLSCert        string
+	TLSKey         string
+	CRL            string
+	VerifyPeer     bool   `toml:"verify_peer"`
+	CertAsUsername string `toml:"cert_as_username"`
+	CertAsClientID string `toml:"cert_as_clientid"`
+	GatewayID      byte   `toml:"gateway_id"`
+	Predefined     map[uint16]string
+	QosM1          bool          `toml:"qos_m1"`
+	QosM1ClientID  string        `toml:"qos_m1_clientid"`
+	QosM1Username  string        `toml:"qos_m1_username"`
+	RetryInterval  time.Duration `toml:"retry_interval"`
+	RetryCount     int           `toml:"retry_count"`
+	MaxBuffered    int           `toml:"max_buffered"`
 }
 
 type Log struct {
@@ -39,12 +51,47 @@ type mqtt struct {
 	SessionExpiryInterval uint32 `toml:"session_expiry_interval"`
 	ReceiveMaximum        uint16 `toml:"max_receive"`
 	ServerKeepAlive       uint16 `toml:"server_keep_alive"`
+	MinKeepAlive          uint16 `toml:"min_keep_alive"`
+	MaxKeepAlive          uint16 `toml:"max_keep_alive"`
 	MaximumPacketSize     uint32 `toml:"max_packet_size"`
 	MaximumQoS            byte   `toml:"max_qos"`
 	WildcardSub           bool   `toml:"wildcard_sub"`
 	SubID                 bool   `toml:"sub_id"`
 	SharedSub             bool   `toml:"shared_sub"`
 	MaxInflight           uint16 `toml:"max_inflight"`
+	MaxQueueLen           int    `toml:"max_queue_len"`
+	MaxQueueSize          int    `toml:"max_queue_size"`
+	QueueDropPolicy       string `toml:"queue_drop_policy"`
+	QueueQos0             bool   `toml:"queue_qos0"`
+	SharedSubStrategy     string `toml:"shared_sub_strategy"`
+	ResponseInfo          string `toml:"response_info"`
+	ClientIDPrefix        string `toml:"client_id_prefix"`
+}
+
+type auth struct {
+	Chain   []string
+	NoMatch string `toml:"no_match"`
+}
+
+type acl struct {
+	NoMatch   string `toml:"no_match"`
+	CacheSize int    `toml:"cache_size"`
+	Rules     []AclRule
+}
+
+type AclRule struct {
+	Permit   string
+	Action   string
+	Username string
+	ClientID string `toml:"clientid"`
+	IPAddr   string `toml:"ipaddr"`
+	Topics   []string
+}
+
+type drain struct {
+	Rate            int
+	Timeout         time.Duration
+	ServerReference string `toml:"server_reference"`
 }
 
 type store struct {
@@ -77,6 +124,9 @@ type config struct {
 	Listeners map[string]*listener
 	Store     store
 	Mqtt      mqtt
+	Auth      auth
+	Acl       acl
+	Drain     drain
 	Cluster   cluster
 	Log       Log
 	Plugins   map[string]Config
@@ -150,12 +200,31 @@ func Init() {
 			SessionExpiryInterval: 60,
 			ReceiveMaximum:        128,
 			ServerKeepAlive:       0,
+			MinKeepAlive:          0,
+			MaxKeepAlive:          0,
 			MaximumPacketSize:     10240,
 			MaximumQoS:            2,
 			WildcardSub:           true,
 			SubID:                 true,
 			SharedSub:             true,
 			MaxInflight:           32,
+			MaxQueueLen:           1000,
+			MaxQueueSize:          0,
+			QueueDropPolicy:       "oldest",
+			QueueQos0:             true,
+			SharedSubStrategy:     "random",
+			ClientIDPrefix:        "gomq-",
+		},
+		Auth: auth{
+			NoMatch: "deny",
+		},
+		Acl: acl{
+			NoMatch:   "allow",
+			CacheSize: 32,
+		},
+		Drain: drain{
+			Rate:    1000,
+			Timeout: 30 * time.Second,
 		},
 		Log: Log{
 			Level:    viper.GetString("log.level"),
@@ -172,15 +241,26 @@ func Init() {
 func (c *config) Parse() error {
 	// Parse listener
 	lns := make(map[string]*listener)
-	for _, t := range []string{"tcp", "tls", "ws", "wss"} {
+	for _, t := range []string{"tcp", "tls", "ws", "wss", "mqttsn"} {
 		ln := &listener{}
+		if t == "mqttsn" {
+			ln.RetryInterval = 10 * time.Second
+			ln.RetryCount = 3
+			ln.MaxBuffered = 1000
+		}
 		if err := viper.UnmarshalKey(t, ln, DecoderConfigOption); err != nil {
 			return err
 		}
+		// identity of qos -1 publishes is never empty
+		if t == "mqttsn" && ln.QosM1ClientID == "" {
+			ln.QosM1ClientID = "mqttsn-qos-m1"
+		}
 		if t == "tls" || t == "wss" {
-			ln.CACert = abs(ln.CACert)
-			ln.TLSCert = abs(ln.TLSCert)
-			ln.TLSKey = abs(ln.TLSKey)
+			for _, p := range []*string{&ln.CACert, &ln.TLSCert, &ln.TLSKey, &ln.CRL} {
+				if *p != "" {
+					*p = abs(*p)
+				}
+			}
 		}
 		ln.Addr = ln.Host + ":" + strconv.Itoa(ln.Port)
 		lns[t] = ln
@@ -211,6 +291,14 @@ func (c *config) Parse() error {
 	return c.Validate()
 }
 
+// Reload config file
+func (c *config) Reload() error {
+	if err := viper.ReadInConfig(); err != nil {
+		return err
+	}
+	return c.Parse()
+}
+
 // Validate Config
 func (c *config) Validate() error {
 	return nil
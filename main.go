@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/laomar/gomq/log"
+	_ "github.com/laomar/gomq/plugin/auth"
 	. "github.com/laomar/gomq/server"
 	"github.com/spf13/cobra"
 )
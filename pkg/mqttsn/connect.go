@@ -0,0 +1,186 @@
+package mqttsn
+
+import (
+	"bytes"
+	"io"
+)
+
+// Connect Packet
+type Connect struct {
+	Flags      Flags
+	ProtocolID byte
+	Duration   uint16
+	ClientID   string
+}
+
+// Pack Connect Packet
+func (c *Connect) Pack(w io.Writer) error {
+	bufw := &bytes.Buffer{}
+	bufw.WriteByte(c.Flags.pack())
+	bufw.WriteByte(c.ProtocolID)
+	writeUint16(bufw, c.Duration)
+	bufw.WriteString(c.ClientID)
+	return write(w, CONNECT, bufw.Bytes())
+}
+
+// Unpack Connect Packet
+func (c *Connect) Unpack(r *bytes.Buffer) error {
+	flags, err := readByte(r)
+	if err != nil {
+		return err
+	}
+	c.Flags = unpackFlags(flags)
+	if c.ProtocolID, err = readByte(r); err != nil {
+		return err
+	}
+	if c.Duration, err = readUint16(r); err != nil {
+		return err
+	}
+	c.ClientID = r.String()
+	return nil
+}
+
+// Connack Packet
+type Connack struct {
+	ReturnCode byte
+}
+
+// Pack Connack Packet
+func (c *Connack) Pack(w io.Writer) error {
+	return write(w, CONNACK, []byte{c.ReturnCode})
+}
+
+// Unpack Connack Packet
+func (c *Connack) Unpack(r *bytes.Buffer) error {
+	var err error
+	c.ReturnCode, err = readByte(r)
+	return err
+}
+
+// WillTopicReq Packet
+type WillTopicReq struct{}
+
+// Pack WillTopicReq Packet
+func (*WillTopicReq) Pack(w io.Writer) error {
+	return write(w, WILLTOPICREQ, nil)
+}
+
+// Unpack WillTopicReq Packet
+func (*WillTopicReq) Unpack(*bytes.Buffer) error {
+	return nil
+}
+
+// WillTopic Packet, only qos and retain of flags are used
+type WillTopic struct {
+	Flags     Flags
+	WillTopic string
+}
+
+// Pack WillTopic Packet
+func (t *WillTopic) Pack(w io.Writer) error {
+	if t.WillTopic == "" {
+		return write(w, WILLTOPIC, nil)
+	}
+	bufw := &bytes.Buffer{}
+	bufw.WriteByte(t.Flags.pack())
+	bufw.WriteString(t.WillTopic)
+	return write(w, WILLTOPIC, bufw.Bytes())
+}
+
+// Unpack WillTopic Packet
+func (t *WillTopic) Unpack(r *bytes.Buffer) error {
+	// empty will topic deletes the will
+	if r.Len() == 0 {
+		return nil
+	}
+	flags, err := readByte(r)
+	if err != nil {
+		return err
+	}
+	t.Flags = unpackFlags(flags)
+	t.WillTopic = r.String()
+	return nil
+}
+
+// WillMsgReq Packet
+type WillMsgReq struct{}
+
+// Pack WillMsgReq Packet
+func (*WillMsgReq) Pack(w io.Writer) error {
+	return write(w, WILLMSGREQ, nil)
+}
+
+// Unpack WillMsgReq Packet
+func (*WillMsgReq) Unpack(*bytes.Buffer) error {
+	return nil
+}
+
+// WillMsg Packet
+type WillMsg struct {
+	WillMsg []byte
+}
+
+// Pack WillMsg Packet
+func (m *WillMsg) Pack(w io.Writer) error {
+	return write(w, WILLMSG, m.WillMsg)
+}
+
+// Unpack WillMsg Packet
+func (m *WillMsg) Unpack(r *bytes.Buffer) error {
+	m.WillMsg = append([]byte{}, r.Bytes()...)
+	return nil
+}
+
+// Pingreq Packet, client id is set by sleeping client to wake up
+type Pingreq struct {
+	ClientID string
+}
+
+// Pack Pingreq Packet
+func (p *Pingreq) Pack(w io.Writer) error {
+	return write(w, PINGREQ, []byte(p.ClientID))
+}
+
+// Unpack Pingreq Packet
+func (p *Pingreq) Unpack(r *bytes.Buffer) error {
+	p.ClientID = r.String()
+	return nil
+}
+
+// Pingresp Packet
+type Pingresp struct{}
+
+// Pack Pingresp Packet
+func (*Pingresp) Pack(w io.Writer) error {
+	return write(w, PINGRESP, nil)
+}
+
+// Unpack Pingresp Packet
+func (*Pingresp) Unpack(*bytes.Buffer) error {
+	return nil
+}
+
+// Disconnect Packet, duration is set by client going to sleep
+type Disconnect struct {
+	Duration uint16
+}
+
+// Pack Disconnect Packet
+func (d *Disconnect) Pack(w io.Writer) error {
+	if d.Duration == 0 {
+		return write(w, DISCONNECT, nil)
+	}
+	bufw := &bytes.Buffer{}
+	writeUint16(bufw, d.Duration)
+	return write(w, DISCONNECT, bufw.Bytes())
+}
+
+// Unpack Disconnect Packet
+func (d *Disconnect) Unpack(r *bytes.Buffer) error {
+	if r.Len() == 0 {
+		return nil
+	}
+	var err error
+	d.Duration, err = readUint16(r)
+	return err
+}
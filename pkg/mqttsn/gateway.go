@@ -0,0 +1,68 @@
+package mqttsn
+
+import (
+	"bytes"
+	"io"
+)
+
+// Advertise Packet
+type Advertise struct {
+	GwID     byte
+	Duration uint16
+}
+
+// Pack Advertise Packet
+func (a *Advertise) Pack(w io.Writer) error {
+	bufw := &bytes.Buffer{}
+	bufw.WriteByte(a.GwID)
+	writeUint16(bufw, a.Duration)
+	return write(w, ADVERTISE, bufw.Bytes())
+}
+
+// Unpack Advertise Packet
+func (a *Advertise) Unpack(r *bytes.Buffer) error {
+	var err error
+	if a.GwID, err = readByte(r); err != nil {
+		return err
+	}
+	a.Duration, err = readUint16(r)
+	return err
+}
+
+// Searchgw Packet
+type Searchgw struct {
+	Radius byte
+}
+
+// Pack Searchgw Packet
+func (s *Searchgw) Pack(w io.Writer) error {
+	return write(w, SEARCHGW, []byte{s.Radius})
+}
+
+// Unpack Searchgw Packet
+func (s *Searchgw) Unpack(r *bytes.Buffer) error {
+	var err error
+	s.Radius, err = readByte(r)
+	return err
+}
+
+// Gwinfo Packet, gateway address is only set when sent by client
+type Gwinfo struct {
+	GwID  byte
+	GwAdd []byte
+}
+
+// Pack Gwinfo Packet
+func (g *Gwinfo) Pack(w io.Writer) error {
+	return write(w, GWINFO, append([]byte{g.GwID}, g.GwAdd...))
+}
+
+// Unpack Gwinfo Packet
+func (g *Gwinfo) Unpack(r *bytes.Buffer) error {
+	var err error
+	if g.GwID, err = readByte(r); err != nil {
+		return err
+	}
+	g.GwAdd = append([]byte{}, r.Bytes()...)
+	return nil
+}
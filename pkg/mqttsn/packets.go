@@ -0,0 +1,232 @@
+package mqttsn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Message type
+const (
+	ADVERTISE    = 0x00
+	SEARCHGW     = 0x01
+	GWINFO       = 0x02
+	CONNECT      = 0x04
+	CONNACK      = 0x05
+	WILLTOPICREQ = 0x06
+	WILLTOPIC    = 0x07
+	WILLMSGREQ   = 0x08
+	WILLMSG      = 0x09
+	REGISTER     = 0x0A
+	REGACK       = 0x0B
+	PUBLISH      = 0x0C
+	PUBACK       = 0x0D
+	PUBCOMP      = 0x0E
+	PUBREC       = 0x0F
+	PUBREL       = 0x10
+	SUBSCRIBE    = 0x12
+	SUBACK       = 0x13
+	UNSUBSCRIBE  = 0x14
+	UNSUBACK     = 0x15
+	PINGREQ      = 0x16
+	PINGRESP     = 0x17
+	DISCONNECT   = 0x18
+)
+
+// Return code
+const (
+	Accepted               = 0x00
+	RejectedCongestion     = 0x01
+	RejectedInvalidTopicID = 0x02
+	RejectedNotSupported   = 0x03
+)
+
+// Topic id type
+const (
+	TopicNormal     = 0x00
+	TopicPredefined = 0x01
+	TopicShort      = 0x02
+)
+
+// QosM1 is qos -1, publish without connection
+const QosM1 byte = 0x03
+
+// ProtocolID of mqtt-sn 1.2
+const ProtocolID = 0x01
+
+var (
+	ErrMalformed = errors.New("mqttsn: malformed packet")
+	ErrUnknown   = errors.New("mqttsn: unknown message type")
+)
+
+// Packet interface, a packet is packed into one datagram
+type Packet interface {
+	Pack(w io.Writer) error
+	Unpack(r *bytes.Buffer) error
+}
+
+// Flags of connect, publish, subscribe and will topic
+type Flags struct {
+	Dup          bool
+	Qos          byte
+	Retain       bool
+	Will         bool
+	CleanSession bool
+	TopicIDType  byte
+}
+
+func (f Flags) pack() byte {
+	var b byte
+	if f.Dup {
+		b |= 0x80
+	}
+	b |= (f.Qos & 0x03) << 5
+	if f.Retain {
+		b |= 0x10
+	}
+	if f.Will {
+		b |= 0x08
+	}
+	if f.CleanSession {
+		b |= 0x04
+	}
+	b |= f.TopicIDType & 0x03
+	return b
+}
+
+func unpackFlags(b byte) Flags {
+	return Flags{
+		Dup:          b&0x80 > 0,
+		Qos:          (b >> 5) & 0x03,
+		Retain:       b&0x10 > 0,
+		Will:         b&0x08 > 0,
+		CleanSession: b&0x04 > 0,
+		TopicIDType:  b & 0x03,
+	}
+}
+
+// ReadPacket decodes one datagram
+func ReadPacket(b []byte) (Packet, error) {
+	if len(b) < 2 {
+		return nil, ErrMalformed
+	}
+	l, n := int(b[0]), 1
+	if l == 0x01 {
+		if len(b) < 4 {
+			return nil, ErrMalformed
+		}
+		l, n = int(binary.BigEndian.Uint16(b[1:3])), 3
+	}
+	if l < n+1 || l > len(b) {
+		return nil, ErrMalformed
+	}
+	p := NewPacket(b[n])
+	if p == nil {
+		return nil, ErrUnknown
+	}
+	if err := p.Unpack(bytes.NewBuffer(b[n+1 : l])); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func NewPacket(t byte) Packet {
+	switch t {
+	case ADVERTISE:
+		return &Advertise{}
+	case SEARCHGW:
+		return &Searchgw{}
+	case GWINFO:
+		return &Gwinfo{}
+	case CONNECT:
+		return &Connect{}
+	case CONNACK:
+		return &Connack{}
+	case WILLTOPICREQ:
+		return &WillTopicReq{}
+	case WILLTOPIC:
+		return &WillTopic{}
+	case WILLMSGREQ:
+		return &WillMsgReq{}
+	case WILLMSG:
+		return &WillMsg{}
+	case REGISTER:
+		return &Register{}
+	case REGACK:
+		return &Regack{}
+	case PUBLISH:
+		return &Publish{}
+	case PUBACK:
+		return &Puback{}
+	case PUBREC:
+		return &Pubrec{}
+	case PUBREL:
+		return &Pubrel{}
+	case PUBCOMP:
+		return &Pubcomp{}
+	case SUBSCRIBE:
+		return &Subscribe{}
+	case SUBACK:
+		return &Suback{}
+	case UNSUBSCRIBE:
+		return &Unsubscribe{}
+	case UNSUBACK:
+		return &Unsuback{}
+	case PINGREQ:
+		return &Pingreq{}
+	case PINGRESP:
+		return &Pingresp{}
+	case DISCONNECT:
+		return &Disconnect{}
+	default:
+		return nil
+	}
+}
+
+// Write message with header in one write, length of 3 bytes is used beyond 255 bytes
+func write(w io.Writer, t byte, body []byte) error {
+	buf := make([]byte, 0, len(body)+4)
+	if l := len(body) + 2; l <= 0xFF {
+		buf = append(buf, byte(l), t)
+	} else {
+		l += 2
+		buf = append(buf, 0x01, byte(l>>8), byte(l), t)
+	}
+	buf = append(buf, body...)
+	_, err := w.Write(buf)
+	return err
+}
+
+func writeUint16(w *bytes.Buffer, i uint16) {
+	w.WriteByte(byte(i >> 8))
+	w.WriteByte(byte(i))
+}
+
+func readUint16(r *bytes.Buffer) (uint16, error) {
+	if r.Len() < 2 {
+		return 0, ErrMalformed
+	}
+	return binary.BigEndian.Uint16(r.Next(2)), nil
+}
+
+func readByte(r *bytes.Buffer) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, ErrMalformed
+	}
+	return b, nil
+}
+
+// Topic id of short topic name
+func ShortTopicID(name string) uint16 {
+	if len(name) != 2 {
+		return 0
+	}
+	return uint16(name[0])<<8 | uint16(name[1])
+}
+
+// Short topic name of topic id
+func ShortTopicName(id uint16) string {
+	return string([]byte{byte(id >> 8), byte(id)})
+}
@@ -0,0 +1,110 @@
+package mqttsn
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var roundTrips = []struct {
+	name string
+	p    Packet
+}{
+	{"advertise", &Advertise{GwID: 1, Duration: 900}},
+	{"searchgw", &Searchgw{Radius: 1}},
+	{"gwinfo", &Gwinfo{GwID: 1, GwAdd: []byte{127, 0, 0, 1}}},
+	{"connect", &Connect{Flags: Flags{Will: true, CleanSession: true}, ProtocolID: ProtocolID, Duration: 30, ClientID: "sn-1"}},
+	{"connack", &Connack{ReturnCode: RejectedCongestion}},
+	{"willtopicreq", &WillTopicReq{}},
+	{"willtopic", &WillTopic{Flags: Flags{Qos: 1, Retain: true}, WillTopic: "will/sn-1"}},
+	{"willtopic empty", &WillTopic{}},
+	{"willmsgreq", &WillMsgReq{}},
+	{"willmsg", &WillMsg{WillMsg: []byte("bye")}},
+	{"register", &Register{TopicID: 1, MsgID: 2, TopicName: "a/b"}},
+	{"regack", &Regack{TopicID: 1, MsgID: 2, ReturnCode: RejectedInvalidTopicID}},
+	{"publish", &Publish{Flags: Flags{Dup: true, Qos: 2, Retain: true}, TopicID: 1, MsgID: 3, Data: []byte("x")}},
+	{"publish qos -1 short", &Publish{Flags: Flags{Qos: QosM1, TopicIDType: TopicShort}, TopicID: ShortTopicID("ab"), Data: []byte("x")}},
+	{"publish long", &Publish{Flags: Flags{Qos: 1}, TopicID: 1, MsgID: 4, Data: bytes.Repeat([]byte("x"), 300)}},
+	{"puback", &Puback{TopicID: 1, MsgID: 3, ReturnCode: Accepted}},
+	{"pubrec", &Pubrec{MsgID: 3}},
+	{"pubrel", &Pubrel{MsgID: 3}},
+	{"pubcomp", &Pubcomp{MsgID: 3}},
+	{"subscribe name", &Subscribe{Flags: Flags{Qos: 1}, MsgID: 5, TopicName: "a/+"}},
+	{"subscribe predefined", &Subscribe{Flags: Flags{Qos: 2, TopicIDType: TopicPredefined}, MsgID: 6, TopicID: 9}},
+	{"suback", &Suback{Flags: Flags{Qos: 1}, TopicID: 1, MsgID: 5, ReturnCode: Accepted}},
+	{"unsubscribe name", &Unsubscribe{MsgID: 7, TopicName: "a/+"}},
+	{"unsubscribe short", &Unsubscribe{Flags: Flags{TopicIDType: TopicShort}, MsgID: 8, TopicID: ShortTopicID("ab")}},
+	{"unsuback", &Unsuback{MsgID: 7}},
+	{"pingreq", &Pingreq{ClientID: "sn-1"}},
+	{"pingresp", &Pingresp{}},
+	{"disconnect", &Disconnect{}},
+	{"disconnect sleep", &Disconnect{Duration: 60}},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, tt := range roundTrips {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := tt.p.Pack(buf); err != nil {
+				t.Fatalf("pack: %v", err)
+			}
+			got, err := ReadPacket(buf.Bytes())
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.p) {
+				t.Fatalf("got %+v, want %+v", got, tt.p)
+			}
+		})
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	for _, tt := range roundTrips {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := tt.p.Pack(buf); err != nil {
+				t.Fatalf("pack: %v", err)
+			}
+			b := buf.Bytes()
+			for n := 0; n < len(b); n++ {
+				if _, err := ReadPacket(b[:n]); err == nil {
+					t.Fatalf("%d of %d bytes: want error", n, len(b))
+				}
+			}
+		})
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		err  error
+	}{
+		{"length below header", []byte{0x01, CONNACK}, ErrMalformed},
+		{"long length below header", []byte{0x01, 0x00, 0x03, CONNACK}, ErrMalformed},
+		{"unknown type", []byte{0x02, 0x03}, ErrUnknown},
+		{"connack without code", []byte{0x02, CONNACK}, ErrMalformed},
+		{"puback short body", []byte{0x05, PUBACK, 0x00, 0x01, 0x00}, ErrMalformed},
+		{"publish without msg id", []byte{0x05, PUBLISH, 0x20, 0x00, 0x01}, ErrMalformed},
+		{"subscribe predefined without id", []byte{0x06, SUBSCRIBE, 0x21, 0x00, 0x01, 0x00}, ErrMalformed},
+		{"disconnect odd duration", []byte{0x03, DISCONNECT, 0x01}, ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadPacket(tt.b); err != tt.err {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestShortTopic(t *testing.T) {
+	if id := ShortTopicID("ab"); ShortTopicName(id) != "ab" {
+		t.Fatalf("got %q, want %q", ShortTopicName(id), "ab")
+	}
+	if id := ShortTopicID("abc"); id != 0 {
+		t.Fatalf("got %d, want 0", id)
+	}
+}
@@ -0,0 +1,179 @@
+package mqttsn
+
+import (
+	"bytes"
+	"io"
+)
+
+// Register Packet
+type Register struct {
+	TopicID   uint16
+	MsgID     uint16
+	TopicName string
+}
+
+// Pack Register Packet
+func (g *Register) Pack(w io.Writer) error {
+	bufw := &bytes.Buffer{}
+	writeUint16(bufw, g.TopicID)
+	writeUint16(bufw, g.MsgID)
+	bufw.WriteString(g.TopicName)
+	return write(w, REGISTER, bufw.Bytes())
+}
+
+// Unpack Register Packet
+func (g *Register) Unpack(r *bytes.Buffer) error {
+	var err error
+	if g.TopicID, err = readUint16(r); err != nil {
+		return err
+	}
+	if g.MsgID, err = readUint16(r); err != nil {
+		return err
+	}
+	g.TopicName = r.String()
+	return nil
+}
+
+// Regack Packet
+type Regack struct {
+	TopicID    uint16
+	MsgID      uint16
+	ReturnCode byte
+}
+
+// Pack Regack Packet
+func (g *Regack) Pack(w io.Writer) error {
+	bufw := &bytes.Buffer{}
+	writeUint16(bufw, g.TopicID)
+	writeUint16(bufw, g.MsgID)
+	bufw.WriteByte(g.ReturnCode)
+	return write(w, REGACK, bufw.Bytes())
+}
+
+// Unpack Regack Packet
+func (g *Regack) Unpack(r *bytes.Buffer) error {
+	var err error
+	if g.TopicID, err = readUint16(r); err != nil {
+		return err
+	}
+	if g.MsgID, err = readUint16(r); err != nil {
+		return err
+	}
+	g.ReturnCode, err = readByte(r)
+	return err
+}
+
+// Publish Packet, topic id of short topic name carries the two characters
+type Publish struct {
+	Flags   Flags
+	TopicID uint16
+	MsgID   uint16
+	Data    []byte
+}
+
+// Pack Publish Packet
+func (p *Publish) Pack(w io.Writer) error {
+	bufw := &bytes.Buffer{}
+	bufw.WriteByte(p.Flags.pack())
+	writeUint16(bufw, p.TopicID)
+	writeUint16(bufw, p.MsgID)
+	bufw.Write(p.Data)
+	return write(w, PUBLISH, bufw.Bytes())
+}
+
+// Unpack Publish Packet
+func (p *Publish) Unpack(r *bytes.Buffer) error {
+	flags, err := readByte(r)
+	if err != nil {
+		return err
+	}
+	p.Flags = unpackFlags(flags)
+	if p.TopicID, err = readUint16(r); err != nil {
+		return err
+	}
+	if p.MsgID, err = readUint16(r); err != nil {
+		return err
+	}
+	p.Data = append([]byte{}, r.Bytes()...)
+	return nil
+}
+
+// Puback Packet, also sent to reject qos 2 publish
+type Puback struct {
+	TopicID    uint16
+	MsgID      uint16
+	ReturnCode byte
+}
+
+// Pack Puback Packet
+func (p *Puback) Pack(w io.Writer) error {
+	bufw := &bytes.Buffer{}
+	writeUint16(bufw, p.TopicID)
+	writeUint16(bufw, p.MsgID)
+	bufw.WriteByte(p.ReturnCode)
+	return write(w, PUBACK, bufw.Bytes())
+}
+
+// Unpack Puback Packet
+func (p *Puback) Unpack(r *bytes.Buffer) error {
+	var err error
+	if p.TopicID, err = readUint16(r); err != nil {
+		return err
+	}
+	if p.MsgID, err = readUint16(r); err != nil {
+		return err
+	}
+	p.ReturnCode, err = readByte(r)
+	return err
+}
+
+// Pubrec Packet
+type Pubrec struct {
+	MsgID uint16
+}
+
+// Pack Pubrec Packet
+func (p *Pubrec) Pack(w io.Writer) error {
+	return write(w, PUBREC, []byte{byte(p.MsgID >> 8), byte(p.MsgID)})
+}
+
+// Unpack Pubrec Packet
+func (p *Pubrec) Unpack(r *bytes.Buffer) error {
+	var err error
+	p.MsgID, err = readUint16(r)
+	return err
+}
+
+// Pubrel Packet
+type Pubrel struct {
+	MsgID uint16
+}
+
+// Pack Pubrel Packet
+func (p *Pubrel) Pack(w io.Writer) error {
+	return write(w, PUBREL, []byte{byte(p.MsgID >> 8), byte(p.MsgID)})
+}
+
+// Unpack Pubrel Packet
+func (p *Pubrel) Unpack(r *bytes.Buffer) error {
+	var err error
+	p.MsgID, err = readUint16(r)
+	return err
+}
+
+// Pubcomp Packet
+type Pubcomp struct {
+	MsgID uint16
+}
+
+// Pack Pubcomp Packet
+func (p *Pubcomp) Pack(w io.Writer) error {
+	return write(w, PUBCOMP, []byte{byte(p.MsgID >> 8), byte(p.MsgID)})
+}
+
+// Unpack Pubcomp Packet
+func (p *Pubcomp) Unpack(r *bytes.Buffer) error {
+	var err error
+	p.MsgID, err = readUint16(r)
+	return err
+}
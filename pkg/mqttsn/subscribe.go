@@ -0,0 +1,127 @@
+package mqttsn
+
+import (
+	"bytes"
+	"io"
+)
+
+// Subscribe Packet, topic name is used by normal topic id type, otherwise topic id
+type Subscribe struct {
+	Flags     Flags
+	MsgID     uint16
+	TopicName string
+	TopicID   uint16
+}
+
+// Pack Subscribe Packet
+func (s *Subscribe) Pack(w io.Writer) error {
+	return write(w, SUBSCRIBE, packTopic(s.Flags, s.MsgID, s.TopicName, s.TopicID))
+}
+
+// Unpack Subscribe Packet
+func (s *Subscribe) Unpack(r *bytes.Buffer) error {
+	var err error
+	s.Flags, s.MsgID, s.TopicName, s.TopicID, err = unpackTopic(r)
+	return err
+}
+
+// Suback Packet
+type Suback struct {
+	Flags      Flags
+	TopicID    uint16
+	MsgID      uint16
+	ReturnCode byte
+}
+
+// Pack Suback Packet
+func (s *Suback) Pack(w io.Writer) error {
+	bufw := &bytes.Buffer{}
+	bufw.WriteByte(s.Flags.pack())
+	writeUint16(bufw, s.TopicID)
+	writeUint16(bufw, s.MsgID)
+	bufw.WriteByte(s.ReturnCode)
+	return write(w, SUBACK, bufw.Bytes())
+}
+
+// Unpack Suback Packet
+func (s *Suback) Unpack(r *bytes.Buffer) error {
+	flags, err := readByte(r)
+	if err != nil {
+		return err
+	}
+	s.Flags = unpackFlags(flags)
+	if s.TopicID, err = readUint16(r); err != nil {
+		return err
+	}
+	if s.MsgID, err = readUint16(r); err != nil {
+		return err
+	}
+	s.ReturnCode, err = readByte(r)
+	return err
+}
+
+// Unsubscribe Packet, topic name is used by normal topic id type, otherwise topic id
+type Unsubscribe struct {
+	Flags     Flags
+	MsgID     uint16
+	TopicName string
+	TopicID   uint16
+}
+
+// Pack Unsubscribe Packet
+func (u *Unsubscribe) Pack(w io.Writer) error {
+	return write(w, UNSUBSCRIBE, packTopic(u.Flags, u.MsgID, u.TopicName, u.TopicID))
+}
+
+// Unpack Unsubscribe Packet
+func (u *Unsubscribe) Unpack(r *bytes.Buffer) error {
+	var err error
+	u.Flags, u.MsgID, u.TopicName, u.TopicID, err = unpackTopic(r)
+	return err
+}
+
+// Unsuback Packet
+type Unsuback struct {
+	MsgID uint16
+}
+
+// Pack Unsuback Packet
+func (u *Unsuback) Pack(w io.Writer) error {
+	return write(w, UNSUBACK, []byte{byte(u.MsgID >> 8), byte(u.MsgID)})
+}
+
+// Unpack Unsuback Packet
+func (u *Unsuback) Unpack(r *bytes.Buffer) error {
+	var err error
+	u.MsgID, err = readUint16(r)
+	return err
+}
+
+func packTopic(flags Flags, msgID uint16, name string, id uint16) []byte {
+	bufw := &bytes.Buffer{}
+	bufw.WriteByte(flags.pack())
+	writeUint16(bufw, msgID)
+	if flags.TopicIDType == TopicNormal {
+		bufw.WriteString(name)
+	} else {
+		writeUint16(bufw, id)
+	}
+	return bufw.Bytes()
+}
+
+func unpackTopic(r *bytes.Buffer) (flags Flags, msgID uint16, name string, id uint16, err error) {
+	var b byte
+	if b, err = readByte(r); err != nil {
+		return
+	}
+	flags = unpackFlags(b)
+	if msgID, err = readUint16(r); err != nil {
+		return
+	}
+	if flags.TopicIDType == TopicNormal {
+		name = r.String()
+		return
+	}
+	id, err = readUint16(r)
+	return
+}
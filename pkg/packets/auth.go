@@ -37,12 +37,15 @@ func (a *Auth) Unpack(r io.Reader) error {
 		return err
 	}
 	bufr := bytes.NewBuffer(buf)
-	if a.ReasonCode, err = bufr.ReadByte(); err != nil {
-		return ErrMalformed
-	}
 	a.Properties = &Properties{}
-	if err = a.Properties.Unpack(bufr); err != nil {
-		return err
+	// reason code and properties are omitted on success
+	if bufr.Len() == 0 {
+		a.ReasonCode = Success
+		return nil
+	}
+	a.ReasonCode, _ = bufr.ReadByte()
+	if bufr.Len() == 0 {
+		return nil
 	}
-	return nil
+	return a.Properties.Unpack(bufr)
 }
@@ -23,9 +23,13 @@ func (c *Connack) Pack(w io.Writer) error {
 		bufw.WriteByte(0)
 	}
 	bufw.WriteByte(c.ReasonCode)
-	if c.Version == V5 && c.Properties != nil {
-		if err := c.Properties.Pack(bufw); err != nil {
-			return err
+	if c.Version == V5 {
+		if c.Properties != nil {
+			if err := c.Properties.Pack(bufw); err != nil {
+				return err
+			}
+		} else {
+			bufw.WriteByte(0)
 		}
 	}
 	c.FixHeader = &FixHeader{
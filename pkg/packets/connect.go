@@ -132,7 +132,7 @@ func (c *Connect) Unpack(r io.Reader) error {
 	if c.WillFlag {
 		if c.Version == V5 {
 			c.WillProperties = &Properties{}
-			if err = c.Properties.Unpack(bufr); err != nil {
+			if err = c.WillProperties.Unpack(bufr); err != nil {
 				return err
 			}
 		}
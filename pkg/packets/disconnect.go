@@ -15,7 +15,26 @@ type Disconnect struct {
 
 // Pack Disconnect Packet
 func (c *Disconnect) Pack(w io.Writer) error {
-	return nil
+	bufw := &bytes.Buffer{}
+	if c.Version == V5 {
+		bufw.WriteByte(c.ReasonCode)
+		if c.Properties != nil {
+			if err := c.Properties.Pack(bufw); err != nil {
+				return err
+			}
+		} else {
+			bufw.WriteByte(0)
+		}
+	}
+	c.FixHeader = &FixHeader{
+		PacketType: DISCONNECT,
+		RemainLen:  bufw.Len(),
+	}
+	if err := c.FixHeader.Pack(w); err != nil {
+		return err
+	}
+	_, err := bufw.WriteTo(w)
+	return err
 }
 
 // Unpack Disconnect Packet
@@ -25,13 +44,15 @@ func (c *Disconnect) Unpack(r io.Reader) error {
 	if _, err = io.ReadFull(r, buf); err != nil {
 		return err
 	}
-	if c.Version == V5 {
+	if c.Version == V5 && len(buf) > 0 {
 		bufr := bytes.NewBuffer(buf)
 		if c.ReasonCode, err = bufr.ReadByte(); err != nil {
 			return err
 		}
 		c.Properties = &Properties{}
-		return c.Properties.Unpack(bufr)
+		if bufr.Len() > 0 {
+			return c.Properties.Unpack(bufr)
+		}
 	}
 	return nil
 }
@@ -32,6 +32,11 @@ func (fh *FixHeader) Pack(w io.Writer) error {
 	return err
 }
 
+// Size of the whole packet
+func (fh *FixHeader) Size() int {
+	return 1 + len(encodeLength(fh.RemainLen)) + fh.RemainLen
+}
+
 // Unpack Fix Header
 func (fh *FixHeader) Unpack(r io.Reader) error {
 	var err error
@@ -74,6 +74,7 @@ const (
 	ServerUnavailable           = 0x88
 	ServerBusy                  = 0x89
 	Banned                      = 0x8A
+	ServerShuttingDown          = 0x8B
 	BadAuthMethod               = 0x8C
 	KeepAliveTimeout            = 0x8D
 	SessionTakenOver            = 0x8E
@@ -102,6 +103,7 @@ const (
 var (
 	ErrMalformed = &Error{Code: MalformedPacket}
 	ErrProtocol  = &Error{Code: ProtocolError}
+	ErrTooLarge  = &Error{Code: PacketTooLarge}
 )
 
 type Error struct {
@@ -143,8 +145,14 @@ func NewPacket(fh *FixHeader, v byte) Packet {
 		return &Pingreq{FixHeader: fh}
 	case PUBLISH:
 		return &Publish{FixHeader: fh, Version: v}
+	case PUBACK:
+		return &Puback{FixHeader: fh, Version: v}
+	case PUBREC:
+		return &Pubrec{FixHeader: fh, Version: v}
 	case PUBREL:
 		return &Pubrel{FixHeader: fh, Version: v}
+	case PUBCOMP:
+		return &Pubcomp{FixHeader: fh, Version: v}
 	case DISCONNECT:
 		return &Disconnect{FixHeader: fh, Version: v}
 	case SUBSCRIBE:
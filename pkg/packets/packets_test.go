@@ -0,0 +1,165 @@
+package packets
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func decode(b []byte, v byte) (Packet, error) {
+	r := bytes.NewReader(b)
+	fh := &FixHeader{}
+	if err := fh.Unpack(r); err != nil {
+		return nil, err
+	}
+	p := NewPacket(fh, v)
+	if p == nil {
+		return nil, ErrMalformed
+	}
+	return p, p.Unpack(r)
+}
+
+func ptr[T any](v T) *T { return &v }
+
+var roundTrips = []struct {
+	name string
+	v    byte
+	p    Packet
+}{
+	{"connect v311", V311, &Connect{
+		FixHeader: &FixHeader{}, Protocol: "MQTT", Version: V311,
+		UsernameFlag: true, PasswordFlag: true, CleanStart: true, KeepAlive: 60,
+		ClientID: "c1", Username: "u", Password: "p",
+	}},
+	{"connect v5 will", V5, &Connect{
+		FixHeader: &FixHeader{}, Protocol: "MQTT", Version: V5,
+		WillFlag: true, WillQos: Qos1, WillRetain: true, KeepAlive: 30,
+		Properties:     &Properties{SessionExpiryInterval: ptr[uint32](3600), ReceiveMaximum: ptr[uint16](10)},
+		ClientID:       "c2",
+		WillProperties: &Properties{WillDelayInterval: ptr[uint32](5)},
+		WillTopic:      "will/c2", WillMsg: "bye",
+	}},
+	{"publish v311 qos0", V311, &Publish{
+		FixHeader: &FixHeader{}, Version: V311, TopicName: "a/b", Payload: []byte("x"),
+	}},
+	{"publish v311 qos1 dup retain", V311, &Publish{
+		FixHeader: &FixHeader{Qos: Qos1, Dup: true, Retain: true}, Version: V311,
+		TopicName: "a/b", PacketID: 7, Payload: []byte("x"),
+	}},
+	{"publish v5 qos2 properties", V5, &Publish{
+		FixHeader: &FixHeader{Qos: Qos2}, Version: V5, TopicName: "a/b", PacketID: 65535,
+		Properties: &Properties{
+			MessageExpiry:          ptr[uint32](60),
+			ContentType:            "text/plain",
+			ResponseTopic:          "r/t",
+			CorrelationData:        "id",
+			SubscriptionIdentifier: []uint32{1, 268435455},
+			TopicAlias:             ptr[uint16](3),
+			User:                   []UserProperty{{"k", "1"}, {"k", "2"}},
+		},
+		Payload: []byte("payload"),
+	}},
+	{"puback v311", V311, &Puback{Version: V311, PacketID: 1}},
+	{"puback v5", V5, &Puback{Version: V5, PacketID: 1, ReasonCode: NotMatchingSubscribers, Properties: &Properties{}}},
+	{"pubrec v5", V5, &Pubrec{Version: V5, PacketID: 2, ReasonCode: QuotaExceeded, Properties: &Properties{ReasonString: "full"}}},
+	{"pubrel v311", V311, &Pubrel{Version: V311, PacketID: 3}},
+	{"pubrel v5", V5, &Pubrel{Version: V5, PacketID: 3, ReasonCode: PacketIDNotFound, Properties: &Properties{}}},
+	{"pubcomp v5", V5, &Pubcomp{Version: V5, PacketID: 4, Properties: &Properties{}}},
+	{"subscribe v311", V311, &Subscribe{
+		Version: V311, PacketID: 5,
+		Subscriptions: []Subscription{{Topic: "a/#", Qos: Qos1}, {Topic: "b/+", Qos: Qos2}},
+	}},
+	{"subscribe v5 options", V5, &Subscribe{
+		Version: V5, PacketID: 6, Properties: &Properties{SubscriptionIdentifier: []uint32{9}},
+		Subscriptions: []Subscription{
+			{Topic: "a/#", Qos: Qos2, NoLocal: true, RetainAsPublished: true, RetainHandling: 2},
+			{Topic: "$share/g/b", Qos: Qos0, RetainHandling: 1},
+		},
+	}},
+	{"disconnect v5", V5, &Disconnect{Version: V5, ReasonCode: SessionTakenOver, Properties: &Properties{ServerReference: "other"}}},
+	{"auth", V5, &Auth{ReasonCode: ContinueAuthentication, Properties: &Properties{AuthMethod: "SCRAM-SHA-256", AuthData: "data"}}},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, tt := range roundTrips {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := tt.p.Pack(buf); err != nil {
+				t.Fatalf("pack: %v", err)
+			}
+			got, err := decode(buf.Bytes(), tt.v)
+			if err != nil {
+				t.Fatalf("unpack: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.p) {
+				t.Fatalf("got %+v, want %+v", got, tt.p)
+			}
+		})
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	for _, tt := range roundTrips {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := tt.p.Pack(buf); err != nil {
+				t.Fatalf("pack: %v", err)
+			}
+			b := buf.Bytes()
+			for n := 0; n < len(b); n++ {
+				if _, err := decode(b[:n], tt.v); err == nil {
+					t.Fatalf("%d of %d bytes: want error", n, len(b))
+				}
+			}
+		})
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		v    byte
+		b    []byte
+		err  error
+	}{
+		{"subscribe without options", V311, []byte{0x82, 0x05, 0x00, 0x01, 0x00, 0x01, 'a'}, ErrMalformed},
+		{"subscribe qos 3", V311, []byte{0x82, 0x06, 0x00, 0x01, 0x00, 0x01, 'a', 0x03}, ErrProtocol},
+		{"unknown packet type", V311, []byte{0x00, 0x00}, ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decode(tt.b, tt.v); !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLength(t *testing.T) {
+	for _, l := range []int{0, 127, 128, 16383, 16384, 2097151, 2097152, 268435455} {
+		got, err := decodeLength(bytes.NewReader(encodeLength(l)))
+		if err != nil || got != l {
+			t.Fatalf("length %d: got %d, %v", l, got, err)
+		}
+	}
+}
+
+func TestSize(t *testing.T) {
+	for _, tt := range roundTrips {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := tt.p.Pack(buf); err != nil {
+				t.Fatalf("pack: %v", err)
+			}
+			n := buf.Len()
+			fh := &FixHeader{}
+			if err := fh.Unpack(buf); err != nil {
+				t.Fatalf("unpack: %v", err)
+			}
+			if got := fh.Size(); got != n {
+				t.Fatalf("got %d, want %d", got, n)
+			}
+		})
+	}
+}
@@ -36,6 +36,12 @@ const (
 	SharedSubAvailable     = 0x2A
 )
 
+// User property, a key may appear more than once and the order is kept
+type UserProperty struct {
+	Key   string
+	Value string
+}
+
 type Properties struct {
 	PayloadFormat          *byte
 	MessageExpiry          *uint32
@@ -59,14 +65,14 @@ type Properties struct {
 	TopicAlias             *uint16
 	MaximumQoS             *byte
 	RetainAvailable        *byte
-	User                   map[string]string
+	User                   []UserProperty
 	MaximumPacketSize      *uint32
 	WildcardSubAvailable   *byte
 	SubIDAvailable         *byte
 	SharedSubAvailable     *byte
 }
 
-// Pack Connect Packet
+// Pack Properties
 func (p *Properties) Pack(w *bytes.Buffer) error {
 	buf := &bytes.Buffer{}
 	if p.PayloadFormat != nil {
@@ -79,15 +85,15 @@ func (p *Properties) Pack(w *bytes.Buffer) error {
 	}
 	if p.ContentType != "" {
 		buf.WriteByte(ContentType)
-		buf.Write([]byte(p.ContentType))
+		buf.Write(encodeString(p.ContentType))
 	}
 	if p.ResponseTopic != "" {
 		buf.WriteByte(ResponseTopic)
-		buf.Write([]byte(p.ResponseTopic))
+		buf.Write(encodeString(p.ResponseTopic))
 	}
 	if p.CorrelationData != "" {
 		buf.WriteByte(CorrelationData)
-		buf.Write([]byte(p.CorrelationData))
+		buf.Write(encodeString(p.CorrelationData))
 	}
 	if len(p.SubscriptionIdentifier) > 0 {
 		for _, si := range p.SubscriptionIdentifier {
@@ -101,7 +107,7 @@ func (p *Properties) Pack(w *bytes.Buffer) error {
 	}
 	if p.AssignedClientID != "" {
 		buf.WriteByte(AssignedClientID)
-		buf.Write([]byte(p.AssignedClientID))
+		buf.Write(encodeString(p.AssignedClientID))
 	}
 	if p.ServerKeepAlive != nil {
 		buf.WriteByte(ServerKeepAlive)
@@ -109,11 +115,11 @@ func (p *Properties) Pack(w *bytes.Buffer) error {
 	}
 	if p.AuthMethod != "" {
 		buf.WriteByte(AuthMethod)
-		buf.Write([]byte(p.AuthMethod))
+		buf.Write(encodeString(p.AuthMethod))
 	}
 	if p.AuthData != "" {
 		buf.WriteByte(AuthData)
-		buf.Write([]byte(p.AuthData))
+		buf.Write(encodeString(p.AuthData))
 	}
 	if p.RequestProblemInfo != nil {
 		buf.WriteByte(RequestProblemInfo)
@@ -129,15 +135,15 @@ func (p *Properties) Pack(w *bytes.Buffer) error {
 	}
 	if p.ResponseInfo != "" {
 		buf.WriteByte(ResponseInfo)
-		buf.Write([]byte(p.ResponseInfo))
+		buf.Write(encodeString(p.ResponseInfo))
 	}
 	if p.ServerReference != "" {
 		buf.WriteByte(ServerReference)
-		buf.Write([]byte(p.ServerReference))
+		buf.Write(encodeString(p.ServerReference))
 	}
 	if p.ReasonString != "" {
 		buf.WriteByte(ReasonString)
-		buf.Write([]byte(p.ReasonString))
+		buf.Write(encodeString(p.ReasonString))
 	}
 	if p.ReceiveMaximum != nil {
 		buf.WriteByte(ReceiveMaximum)
@@ -159,10 +165,10 @@ func (p *Properties) Pack(w *bytes.Buffer) error {
 		buf.WriteByte(RetainAvailable)
 		buf.WriteByte(*p.RetainAvailable)
 	}
-	for k, v := range p.User {
+	for _, u := range p.User {
 		buf.WriteByte(User)
-		buf.Write(encodeString(k))
-		buf.Write(encodeString(v))
+		buf.Write(encodeString(u.Key))
+		buf.Write(encodeString(u.Value))
 	}
 	if p.MaximumPacketSize != nil {
 		buf.WriteByte(MaximumPacketSize)
@@ -269,12 +275,9 @@ func (p *Properties) Unpack(r *bytes.Buffer) error {
 			b, err = buf.ReadByte()
 			p.RetainAvailable = &b
 		case User:
-			if p.User == nil {
-				p.User = make(map[string]string)
-			}
 			k := decodeString(buf)
 			v := decodeString(buf)
-			p.User[k] = v
+			p.User = append(p.User, UserProperty{Key: k, Value: v})
 		case MaximumPacketSize:
 			u := readUint32(buf)
 			p.MaximumPacketSize = &u
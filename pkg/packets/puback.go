@@ -39,5 +39,21 @@ func (p *Puback) Pack(w io.Writer) error {
 
 // Unpack Puback Packet
 func (p *Puback) Unpack(r io.Reader) error {
+	var err error
+	buf := make([]byte, p.FixHeader.RemainLen)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	bufr := bytes.NewBuffer(buf)
+	p.PacketID = readUint16(bufr)
+	if p.Version == V5 && bufr.Len() > 0 {
+		if p.ReasonCode, err = bufr.ReadByte(); err != nil {
+			return err
+		}
+		p.Properties = &Properties{}
+		if bufr.Len() > 0 {
+			return p.Properties.Unpack(bufr)
+		}
+	}
 	return nil
 }
@@ -16,7 +16,33 @@ type Publish struct {
 
 // Pack Publish Packet
 func (p *Publish) Pack(w io.Writer) error {
-	return nil
+	bufw := &bytes.Buffer{}
+	bufw.Write(encodeString(p.TopicName))
+	if p.FixHeader.Qos > Qos0 {
+		writeUint16(bufw, p.PacketID)
+	}
+	if p.Version == V5 {
+		if p.Properties != nil {
+			if err := p.Properties.Pack(bufw); err != nil {
+				return err
+			}
+		} else {
+			bufw.WriteByte(0)
+		}
+	}
+	bufw.Write(p.Payload)
+	p.FixHeader = &FixHeader{
+		PacketType: PUBLISH,
+		Dup:        p.FixHeader.Dup,
+		Qos:        p.FixHeader.Qos,
+		Retain:     p.FixHeader.Retain,
+		RemainLen:  bufw.Len(),
+	}
+	if err := p.FixHeader.Pack(w); err != nil {
+		return err
+	}
+	_, err := bufw.WriteTo(w)
+	return err
 }
 
 // Unpack Publish Packet
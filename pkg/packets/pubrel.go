@@ -16,7 +16,26 @@ type Pubrel struct {
 
 // Pack Pubrel Packet
 func (p *Pubrel) Pack(w io.Writer) error {
-	return nil
+	bufw := &bytes.Buffer{}
+	writeUint16(bufw, p.PacketID)
+	if p.Version == V5 {
+		bufw.WriteByte(p.ReasonCode)
+		if p.Properties != nil {
+			p.Properties.Pack(bufw)
+		} else {
+			bufw.WriteByte(0)
+		}
+	}
+	p.FixHeader = &FixHeader{
+		PacketType: PUBREL,
+		Flags:      0x02,
+		RemainLen:  bufw.Len(),
+	}
+	if err := p.FixHeader.Pack(w); err != nil {
+		return err
+	}
+	_, err := bufw.WriteTo(w)
+	return err
 }
 
 // Unpack Pubrel Packet
@@ -28,11 +47,14 @@ func (p *Pubrel) Unpack(r io.Reader) error {
 	}
 	bufr := bytes.NewBuffer(buf)
 	p.PacketID = readUint16(bufr)
-	if p.Version == V5 {
-		p.Properties = &Properties{}
-		if err = p.Properties.Unpack(bufr); err != nil {
+	if p.Version == V5 && bufr.Len() > 0 {
+		if p.ReasonCode, err = bufr.ReadByte(); err != nil {
 			return err
 		}
+		p.Properties = &Properties{}
+		if bufr.Len() > 0 {
+			return p.Properties.Unpack(bufr)
+		}
 	}
 	return nil
 }
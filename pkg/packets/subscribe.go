@@ -24,7 +24,41 @@ type Subscribe struct {
 
 // Pack Subscribe Packet
 func (s *Subscribe) Pack(w io.Writer) error {
-	return nil
+	bufw := &bytes.Buffer{}
+	writeUint16(bufw, s.PacketID)
+	if s.Version == V5 {
+		if s.Properties != nil {
+			if err := s.Properties.Pack(bufw); err != nil {
+				return err
+			}
+		} else {
+			bufw.WriteByte(0)
+		}
+	}
+	for _, sub := range s.Subscriptions {
+		bufw.Write(encodeString(sub.Topic))
+		opts := sub.Qos
+		if s.Version == V5 {
+			opts |= sub.RetainHandling << 4
+			if sub.RetainAsPublished {
+				opts |= 0x08
+			}
+			if sub.NoLocal {
+				opts |= 0x04
+			}
+		}
+		bufw.WriteByte(opts)
+	}
+	s.FixHeader = &FixHeader{
+		PacketType: SUBSCRIBE,
+		Flags:      0x02,
+		RemainLen:  bufw.Len(),
+	}
+	if err := s.FixHeader.Pack(w); err != nil {
+		return err
+	}
+	_, err := bufw.WriteTo(w)
+	return err
 }
 
 // Unpack Subscribe Packet
@@ -18,14 +18,15 @@ type Unsuback struct {
 func (u *Unsuback) Pack(w io.Writer) error {
 	bufw := &bytes.Buffer{}
 	writeUint16(bufw, u.PacketID)
+	// v3 unsuback has no payload
 	if u.Version == V5 {
 		if u.Properties != nil {
 			u.Properties.Pack(bufw)
 		} else {
 			bufw.WriteByte(0)
 		}
+		bufw.Write(u.Payload)
 	}
-	bufw.Write(u.Payload)
 	u.FixHeader = &FixHeader{
 		PacketType: UNSUBACK,
 		RemainLen:  bufw.Len(),
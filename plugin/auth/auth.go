@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/server"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// Whether authenticator is in the auth chain, authenticators out of the chain are not loaded
+func enabled(name string) bool {
+	for _, n := range config.Cfg.Auth.Chain {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Compare password with hash, bcrypt hashes start with $2, sha256 hashes are $sha256$[salt$]hex
+// and scram verifiers start with the scram method
+func compare(hash, password string) bool {
+	if strings.HasPrefix(hash, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	if strings.HasPrefix(hash, "SCRAM-") {
+		v, err := parseVerifier(hash)
+		return err == nil && v.verify(password)
+	}
+	if !strings.HasPrefix(hash, "$sha256$") {
+		return false
+	}
+	parts := strings.Split(strings.TrimPrefix(hash, "$sha256$"), "$")
+	var salt string
+	switch len(parts) {
+	case 1:
+	case 2:
+		salt = parts[0]
+	default:
+		return false
+	}
+	sum := sha256.Sum256([]byte(salt + password))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(parts[len(parts)-1]))) == 1
+}
+
+// Check password against hash, unknown user is left to the next authenticator
+func check(hash string, found bool, password string) byte {
+	if !found {
+		return server.AuthIgnore
+	}
+	if compare(hash, password) {
+		return server.AuthAllow
+	}
+	return server.AuthDeny
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/log"
+	"github.com/laomar/gomq/pkg/packets"
+	"github.com/laomar/gomq/server"
+	"os"
+	"strings"
+)
+
+const fileName = "auth_file"
+
+type fileConfig struct {
+	Path string
+}
+
+func (c *fileConfig) Validate() error {
+	if c.Path == "" {
+		return errors.New("auth_file: path is empty")
+	}
+	return nil
+}
+
+// File authenticator, credentials are lines of username:hash
+type file struct {
+	cfg         *fileConfig
+	credentials map[string]string
+}
+
+func init() {
+	server.RegPlugin(fileName, newFile)
+}
+
+func newFile() (server.Plugin, error) {
+	cfg := &fileConfig{}
+	config.ParsePlugin(fileName, cfg)
+	return &file{
+		cfg:         cfg,
+		credentials: make(map[string]string),
+	}, nil
+}
+
+func (f *file) Name() string {
+	return fileName
+}
+
+func (f *file) Load() error {
+	if !enabled(fileName) {
+		return nil
+	}
+	if err := f.cfg.Validate(); err != nil {
+		return err
+	}
+	fp, err := os.Open(f.cfg.Path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	credentials := make(map[string]string)
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		username, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		credentials[username] = hash
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	f.credentials = credentials
+	log.Infof("auth_file: loaded %d credentials", len(credentials))
+	return nil
+}
+
+func (f *file) Unload() error {
+	return nil
+}
+
+func (f *file) AuthMethods() []string {
+	return scramMethods
+}
+
+func (f *file) NewExchange(c *server.Client, method string) server.AuthExchange {
+	return newScram(method, func(username string) (string, error) {
+		return f.credentials[username], nil
+	})
+}
+
+func (f *file) Authenticate(c *server.Client, pc *packets.Connect) (byte, error) {
+	hash, ok := f.credentials[pc.Username]
+	return check(hash, ok, pc.Password), nil
+}
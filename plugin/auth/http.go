@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/pkg/packets"
+	"github.com/laomar/gomq/server"
+	"io"
+	"net/http"
+	"time"
+)
+
+const httpName = "auth_http"
+
+type httpConfig struct {
+	URL     string
+	Timeout time.Duration
+}
+
+func (c *httpConfig) Validate() error {
+	if c.URL == "" {
+		return errors.New("auth_http: url is empty")
+	}
+	return nil
+}
+
+// HTTP authenticator, the credential is posted as json and the response is
+// 200 with {"result": "allow" | "deny" | "ignore"}, 401 and 403 mean deny
+type httpAuth struct {
+	cfg    *httpConfig
+	client *http.Client
+}
+
+type httpRequest struct {
+	ClientID string `json:"clientid"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	IP       string `json:"ip"`
+	Protocol string `json:"protocol"`
+}
+
+type httpResponse struct {
+	Result string `json:"result"`
+}
+
+func init() {
+	server.RegPlugin(httpName, newHttp)
+}
+
+func newHttp() (server.Plugin, error) {
+	cfg := &httpConfig{
+		Timeout: 5 * time.Second,
+	}
+	config.ParsePlugin(httpName, cfg)
+	return &httpAuth{
+		cfg:    cfg,
+		client: &http.Client{},
+	}, nil
+}
+
+func (h *httpAuth) Name() string {
+	return httpName
+}
+
+func (h *httpAuth) Load() error {
+	if !enabled(httpName) {
+		return nil
+	}
+	return h.cfg.Validate()
+}
+
+func (h *httpAuth) Unload() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
+
+func (h *httpAuth) Authenticate(c *server.Client, pc *packets.Connect) (byte, error) {
+	body, _ := json.Marshal(&httpRequest{
+		ClientID: pc.ClientID,
+		Username: pc.Username,
+		Password: pc.Password,
+		IP:       c.RemoteAddr().String(),
+		Protocol: pc.Protocol,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), h.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return server.AuthIgnore, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return server.AuthIgnore, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return server.AuthDeny, nil
+	default:
+		return server.AuthIgnore, fmt.Errorf("http status %d", resp.StatusCode)
+	}
+	res := &httpResponse{}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(res); err != nil {
+		return server.AuthIgnore, err
+	}
+	switch res.Result {
+	case "allow":
+		return server.AuthAllow, nil
+	case "deny":
+		return server.AuthDeny, nil
+	}
+	return server.AuthIgnore, nil
+}
@@ -0,0 +1,321 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/log"
+	"github.com/laomar/gomq/pkg/packets"
+	"github.com/laomar/gomq/server"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const jwtName = "auth_jwt"
+
+type jwtConfig struct {
+	From      string
+	Secret    string
+	PublicKey string `toml:"public_key"`
+	Jwks      string
+	AclClaim  string `toml:"acl_claim"`
+}
+
+func (c *jwtConfig) Validate() error {
+	if c.From != "password" && c.From != "username" {
+		return fmt.Errorf("auth_jwt: invalid from %s", c.From)
+	}
+	if c.Secret == "" && c.PublicKey == "" && c.Jwks == "" {
+		return errors.New("auth_jwt: no key")
+	}
+	return nil
+}
+
+type jwtKey struct {
+	kid string
+	alg string
+	key any
+}
+
+// JWT authenticator, the token is taken from password or username and verified by HS256, RS256 or ES256
+type jwtAuth struct {
+	sync.RWMutex
+	cfg  *jwtConfig
+	keys []*jwtKey
+}
+
+func init() {
+	server.RegPlugin(jwtName, newJwt)
+}
+
+func newJwt() (server.Plugin, error) {
+	cfg := &jwtConfig{
+		From:     "password",
+		AclClaim: "acl",
+	}
+	config.ParsePlugin(jwtName, cfg)
+	return &jwtAuth{
+		cfg: cfg,
+	}, nil
+}
+
+func (j *jwtAuth) Name() string {
+	return jwtName
+}
+
+func (j *jwtAuth) Load() error {
+	if !enabled(jwtName) {
+		return nil
+	}
+	if err := j.cfg.Validate(); err != nil {
+		return err
+	}
+	keys := make([]*jwtKey, 0)
+	if j.cfg.Secret != "" {
+		keys = append(keys, &jwtKey{alg: "HS256", key: []byte(j.cfg.Secret)})
+	}
+	if j.cfg.PublicKey != "" {
+		key, err := loadPublicKey(j.cfg.PublicKey)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	if j.cfg.Jwks != "" {
+		jwks, err := loadJwks(j.cfg.Jwks)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, jwks...)
+	}
+	j.Lock()
+	j.keys = keys
+	j.Unlock()
+	log.Infof("auth_jwt: loaded %d keys", len(keys))
+	return nil
+}
+
+// Reload keys, the jwks file is read again
+func (j *jwtAuth) Reload() error {
+	return j.Load()
+}
+
+func (j *jwtAuth) Unload() error {
+	return nil
+}
+
+// Load RSA or EC public key of pem file
+func loadPublicKey(path string) (*jwtKey, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bs)
+	if block == nil {
+		return nil, fmt.Errorf("auth_jwt: no pem block in %s", path)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return &jwtKey{alg: "RS256", key: key}, nil
+	case *ecdsa.PublicKey:
+		return &jwtKey{alg: "ES256", key: key}, nil
+	}
+	return nil, fmt.Errorf("auth_jwt: unsupported key in %s", path)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// Load keys of jwks file, keys of unsupported type are skipped
+func loadJwks(path string) ([]*jwtKey, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err = json.Unmarshal(bs, &set); err != nil {
+		return nil, err
+	}
+	b64 := base64.RawURLEncoding
+	keys := make([]*jwtKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		key := &jwtKey{kid: k.Kid}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := b64.DecodeString(k.N)
+			e, err2 := b64.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("auth_jwt: invalid rsa key %s", k.Kid)
+			}
+			key.alg = "RS256"
+			key.key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, err1 := b64.DecodeString(k.X)
+			y, err2 := b64.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("auth_jwt: invalid ec key %s", k.Kid)
+			}
+			key.alg = "ES256"
+			key.key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		case "oct":
+			secret, err := b64.DecodeString(k.K)
+			if err != nil {
+				return nil, fmt.Errorf("auth_jwt: invalid oct key %s", k.Kid)
+			}
+			key.alg = "HS256"
+			key.key = secret
+		default:
+			continue
+		}
+		if k.Alg != "" && k.Alg != key.alg {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Verify signature of token with the keys of its alg, the key of kid is used when present
+func (j *jwtAuth) verify(alg, kid, signed string, sig []byte) bool {
+	defer j.RUnlock()
+	j.RLock()
+	sum := sha256.Sum256([]byte(signed))
+	for _, k := range j.keys {
+		if k.alg != alg || kid != "" && k.kid != "" && k.kid != kid {
+			continue
+		}
+		switch key := k.key.(type) {
+		case []byte:
+			m := hmac.New(sha256.New, key)
+			m.Write([]byte(signed))
+			if hmac.Equal(m.Sum(nil), sig) {
+				return true
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil {
+				return true
+			}
+		case *ecdsa.PublicKey:
+			if len(sig) == 64 && ecdsa.Verify(key, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Parse and verify token, returns its claims
+func (j *jwtAuth) parse(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	b64 := base64.RawURLEncoding
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	bs, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(bs, &header); err != nil {
+		return nil, err
+	}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	if !j.verify(header.Alg, header.Kid, parts[0]+"."+parts[1], sig) {
+		return nil, fmt.Errorf("invalid signature alg=%s kid=%s", header.Alg, header.Kid)
+	}
+	claims := make(map[string]any)
+	if bs, err = b64.DecodeString(parts[1]); err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(bs, &claims); err != nil {
+		return nil, err
+	}
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(float64); ok && now >= exp {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return nil, errors.New("token not valid yet")
+	}
+	return claims, nil
+}
+
+// Topic filters of acl claim, {"pub": [...], "sub": [...], "all": [...]}
+func aclClaim(claim any) *server.ClientAcl {
+	m, ok := claim.(map[string]any)
+	if !ok {
+		return nil
+	}
+	filters := func(key string) []string {
+		fs := make([]string, 0)
+		vs, _ := m[key].([]any)
+		for _, v := range vs {
+			if f, ok := v.(string); ok {
+				fs = append(fs, f)
+			}
+		}
+		return fs
+	}
+	all := filters("all")
+	return &server.ClientAcl{
+		Pub: append(filters("pub"), all...),
+		Sub: append(filters("sub"), all...),
+	}
+}
+
+// Token not in jwt format is left to the next authenticator
+func (j *jwtAuth) Authenticate(c *server.Client, pc *packets.Connect) (byte, error) {
+	token := pc.Password
+	if j.cfg.From == "username" {
+		token = pc.Username
+	}
+	if strings.Count(token, ".") != 2 {
+		return server.AuthIgnore, nil
+	}
+	claims, err := j.parse(token)
+	if err != nil {
+		log.Debugf("auth_jwt: %v cid=%s", err, pc.ClientID)
+		return server.AuthDeny, nil
+	}
+	if acl := aclClaim(claims[j.cfg.AclClaim]); acl != nil {
+		c.SetAcl(acl)
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		c.ExpireAt(time.Unix(int64(exp), 0))
+	}
+	return server.AuthAllow, nil
+}
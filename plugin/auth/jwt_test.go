@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/laomar/gomq/pkg/packets"
+	"github.com/laomar/gomq/server"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Sign token with the key of alg, the signature is left empty for unknown alg
+func signJwt(alg, kid string, key any, claims map[string]any) string {
+	b64 := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		m := hmac.New(sha256.New, k)
+		m.Write([]byte(signed))
+		sig = m.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, k, sum[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func TestJwtParse(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret := []byte("secret")
+	j := &jwtAuth{
+		cfg: &jwtConfig{From: "password", AclClaim: "acl"},
+		keys: []*jwtKey{
+			{alg: "HS256", key: secret},
+			{kid: "r1", alg: "RS256", key: &rsaKey.PublicKey},
+			{kid: "e1", alg: "ES256", key: &ecKey.PublicKey},
+			{kid: "e2", alg: "ES256", key: &otherKey.PublicKey},
+		},
+	}
+	now := time.Now().Unix()
+	claims := map[string]any{"sub": "u"}
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"hs256", signJwt("HS256", "", secret, claims), true},
+		{"rs256", signJwt("RS256", "r1", rsaKey, claims), true},
+		{"es256", signJwt("ES256", "e1", ecKey, claims), true},
+		{"es256 without kid", signJwt("ES256", "", otherKey, claims), true},
+		{"es256 of other kid", signJwt("ES256", "e2", ecKey, claims), false},
+		{"wrong secret", signJwt("HS256", "", []byte("other"), claims), false},
+		{"alg none", signJwt("none", "", nil, claims), false},
+		{"hmac signed as rs256", signJwt("RS256", "r1", secret, claims), false},
+		{"not expired", signJwt("HS256", "", secret, map[string]any{"exp": now + 60}), true},
+		{"expired", signJwt("HS256", "", secret, map[string]any{"exp": now - 1}), false},
+		{"not valid yet", signJwt("HS256", "", secret, map[string]any{"nbf": now + 60}), false},
+		{"valid now", signJwt("HS256", "", secret, map[string]any{"nbf": now - 60}), true},
+		{"bad header", "!.e30.", false},
+		{"bad signature", signJwt("HS256", "", secret, claims) + "!", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := j.parse(tt.token); (err == nil) != tt.ok {
+				t.Fatalf("got %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestJwtIgnore(t *testing.T) {
+	j := &jwtAuth{cfg: &jwtConfig{From: "username"}}
+	code, err := j.Authenticate(nil, &packets.Connect{Username: "user", Password: "a.b.c"})
+	if err != nil || code != server.AuthIgnore {
+		t.Fatalf("got %d %v, want ignore of username not in jwt format", code, err)
+	}
+}
+
+func TestAclClaim(t *testing.T) {
+	tests := []struct {
+		name  string
+		claim string
+		want  *server.ClientAcl
+	}{
+		{"no claim", `null`, nil},
+		{"not an object", `["a"]`, nil},
+		{"empty", `{}`, &server.ClientAcl{Pub: []string{}, Sub: []string{}}},
+		{"pub sub all", `{"pub":["p"],"sub":["s",1],"all":["a/%u"]}`, &server.ClientAcl{Pub: []string{"p", "a/%u"}, Sub: []string{"s", "a/%u"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claim any
+			if err := json.Unmarshal([]byte(tt.claim), &claim); err != nil {
+				t.Fatal(err)
+			}
+			if got := aclClaim(claim); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Substituted client id and username of claim filters can not widen the filters
+func TestAclClaimAllow(t *testing.T) {
+	var claim any
+	if err := json.Unmarshal([]byte(`{"all":["devices/%c/#","users/%u/#"]}`), &claim); err != nil {
+		t.Fatal(err)
+	}
+	acl := aclClaim(claim)
+	tests := []struct {
+		name     string
+		cid      string
+		username string
+		action   string
+		topic    string
+		want     bool
+	}{
+		{"own device", "d1", "", server.AclPublish, "devices/d1/t", true},
+		{"other device", "d1", "", server.AclPublish, "devices/d2/t", false},
+		{"wildcard client id", "#", "", server.AclSubscribe, "devices/#", false},
+		{"wildcard client id publish", "#", "", server.AclPublish, "devices/d2/t", false},
+		{"single level client id", "+", "", server.AclSubscribe, "devices/+/t", false},
+		{"separator client id", "d1/x", "", server.AclPublish, "devices/d1/x/t", false},
+		{"own user", "d1", "u", server.AclSubscribe, "users/u/#", true},
+		{"empty username", "d1", "", server.AclPublish, "users//t", false},
+		{"wildcard username", "d1", "+", server.AclSubscribe, "users/+/t", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := acl.Allow(tt.cid, tt.username, tt.action, tt.topic); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/pbkdf2"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// SCRAM auth method
+const (
+	ScramSha1   = "SCRAM-SHA-1"
+	ScramSha256 = "SCRAM-SHA-256"
+)
+
+var scramMethods = []string{ScramSha1, ScramSha256}
+
+var errScram = errors.New("scram: invalid message")
+
+// SCRAM verifier, stored as <method>$<iterations>:<salt>$<stored key>:<server key> in base64
+type scramVerifier struct {
+	method    string
+	iter      int
+	salt      []byte
+	storedKey []byte
+	serverKey []byte
+}
+
+func scramHash(method string) func() hash.Hash {
+	if method == ScramSha1 {
+		return sha1.New
+	}
+	return sha256.New
+}
+
+func parseVerifier(s string) (*scramVerifier, error) {
+	method, rest, _ := strings.Cut(s, "$")
+	if method != ScramSha1 && method != ScramSha256 {
+		return nil, errors.New("scram: not a verifier")
+	}
+	params, keys, _ := strings.Cut(rest, "$")
+	iter, salt, _ := strings.Cut(params, ":")
+	stored, server, _ := strings.Cut(keys, ":")
+	v := &scramVerifier{method: method}
+	var err error
+	if v.iter, err = strconv.Atoi(iter); err != nil || v.iter <= 0 {
+		return nil, errors.New("scram: invalid iterations")
+	}
+	if v.salt, err = base64.StdEncoding.DecodeString(salt); err != nil {
+		return nil, err
+	}
+	if v.storedKey, err = base64.StdEncoding.DecodeString(stored); err != nil {
+		return nil, err
+	}
+	if v.serverKey, err = base64.StdEncoding.DecodeString(server); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func hmacSum(h func() hash.Hash, key []byte, msg string) []byte {
+	m := hmac.New(h, key)
+	m.Write([]byte(msg))
+	return m.Sum(nil)
+}
+
+// Verify plain password against verifier
+func (v *scramVerifier) verify(password string) bool {
+	h := scramHash(v.method)
+	salted := pbkdf2.Key([]byte(password), v.salt, v.iter, h().Size(), h)
+	sum := h()
+	sum.Write(hmacSum(h, salted, "Client Key"))
+	return subtle.ConstantTimeCompare(sum.Sum(nil), v.storedKey) == 1
+}
+
+// Secret of fake verifiers, salt of an unknown user is the same in every exchange
+var fakeSecret = func() []byte {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return b
+}()
+
+// Verifier of unknown user, the random keys match no proof
+func fakeVerifier(method, username string) *scramVerifier {
+	h := scramHash(method)
+	v := &scramVerifier{
+		method:    method,
+		iter:      4096,
+		salt:      hmacSum(sha256.New, fakeSecret, method+":"+username)[:16],
+		storedKey: make([]byte, h().Size()),
+		serverKey: make([]byte, h().Size()),
+	}
+	_, _ = rand.Read(v.storedKey)
+	_, _ = rand.Read(v.serverKey)
+	return v
+}
+
+// SCRAM exchange of RFC 5802 without channel binding
+type scram struct {
+	method      string
+	lookup      func(string) (string, error)
+	username    string
+	gs2Header   string
+	clientFirst string
+	serverFirst string
+	nonce       string
+	verifier    *scramVerifier
+	done        bool
+}
+
+func newScram(method string, lookup func(string) (string, error)) *scram {
+	return &scram{
+		method: method,
+		lookup: lookup,
+	}
+}
+
+func (s *scram) Username() string {
+	return s.username
+}
+
+func (s *scram) Next(data string) (string, bool, error) {
+	if s.done {
+		return "", false, errScram
+	}
+	if s.verifier == nil {
+		return s.first(data)
+	}
+	s.done = true
+	return s.final(data)
+}
+
+// Parse attributes of message
+func scramAttrs(msg string) map[byte]string {
+	attrs := make(map[byte]string)
+	for _, attr := range strings.Split(msg, ",") {
+		if len(attr) >= 2 && attr[1] == '=' {
+			attrs[attr[0]] = attr[2:]
+		}
+	}
+	return attrs
+}
+
+// Handle client first message n,,n=<user>,r=<nonce>, returns server first message
+func (s *scram) first(data string) (string, bool, error) {
+	if !strings.HasPrefix(data, "n,,") && !strings.HasPrefix(data, "y,,") {
+		return "", false, errors.New("scram: channel binding not supported")
+	}
+	s.gs2Header, s.clientFirst = data[:3], data[3:]
+	attrs := scramAttrs(s.clientFirst)
+	cnonce := attrs['r']
+	if attrs['n'] == "" || cnonce == "" {
+		return "", false, errScram
+	}
+	s.username = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(attrs['n'])
+
+	hash, err := s.lookup(s.username)
+	if err != nil {
+		return "", false, err
+	}
+	// user without verifier of the method fails at client final, so users can not be enumerated
+	if s.verifier, err = parseVerifier(hash); err != nil || s.verifier.method != s.method {
+		s.verifier = fakeVerifier(s.method, s.username)
+	}
+
+	b := make([]byte, 18)
+	if _, err = rand.Read(b); err != nil {
+		return "", false, err
+	}
+	s.nonce = cnonce + base64.RawStdEncoding.EncodeToString(b)
+	s.serverFirst = fmt.Sprintf("r=%s,s=%s,i=%d", s.nonce, base64.StdEncoding.EncodeToString(s.verifier.salt), s.verifier.iter)
+	return s.serverFirst, false, nil
+}
+
+// Handle client final message c=<binding>,r=<nonce>,p=<proof>, returns server final message
+func (s *scram) final(data string) (string, bool, error) {
+	withoutProof, proof, ok := strings.Cut(data, ",p=")
+	if !ok {
+		return "", false, errScram
+	}
+	attrs := scramAttrs(withoutProof)
+	// channel binding repeats the gs2 header of client first message
+	if binding, err := base64.StdEncoding.DecodeString(attrs['c']); err != nil || string(binding) != s.gs2Header {
+		return "", false, errors.New("scram: channel binding mismatch")
+	}
+	if attrs['r'] != s.nonce {
+		return "", false, errors.New("scram: nonce mismatch")
+	}
+	clientProof, err := base64.StdEncoding.DecodeString(proof)
+	if err != nil {
+		return "", false, errScram
+	}
+
+	h := scramHash(s.method)
+	authMessage := s.clientFirst + "," + s.serverFirst + "," + withoutProof
+	signature := hmacSum(h, s.verifier.storedKey, authMessage)
+	if len(clientProof) != len(signature) {
+		return "", false, errScram
+	}
+	clientKey := make([]byte, len(signature))
+	for i := range signature {
+		clientKey[i] = clientProof[i] ^ signature[i]
+	}
+	sum := h()
+	sum.Write(clientKey)
+	if subtle.ConstantTimeCompare(sum.Sum(nil), s.verifier.storedKey) != 1 {
+		return "", false, errors.New("scram: invalid proof")
+	}
+	serverSignature := hmacSum(h, s.verifier.serverKey, authMessage)
+	return "v=" + base64.StdEncoding.EncodeToString(serverSignature), true, nil
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/pbkdf2"
+	"strings"
+	"testing"
+)
+
+// Verifier of password in the stored format
+func makeVerifier(method, password string) string {
+	h := scramHash(method)
+	salt := []byte("0123456789abcdef")
+	salted := pbkdf2.Key([]byte(password), salt, 4096, h().Size(), h)
+	sum := h()
+	sum.Write(hmacSum(h, salted, "Client Key"))
+	b64 := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("%s$%d:%s$%s:%s", method, 4096, b64(salt), b64(sum.Sum(nil)), b64(hmacSum(h, salted, "Server Key")))
+}
+
+// Client final message and expected server final message of the server first message,
+// binding is the gs2 header the client final message repeats
+func clientFinal(method, password, binding, clientFirst, serverFirst string) (string, string) {
+	h := scramHash(method)
+	attrs := scramAttrs(serverFirst)
+	salt, _ := base64.StdEncoding.DecodeString(attrs['s'])
+	var iter int
+	fmt.Sscan(attrs['i'], &iter)
+	salted := pbkdf2.Key([]byte(password), salt, iter, h().Size(), h)
+	clientKey := hmacSum(h, salted, "Client Key")
+	sum := h()
+	sum.Write(clientKey)
+	withoutProof := "c=" + base64.StdEncoding.EncodeToString([]byte(binding)) + ",r=" + attrs['r']
+	authMessage := clientFirst + "," + serverFirst + "," + withoutProof
+	signature := hmacSum(h, sum.Sum(nil), authMessage)
+	for i := range clientKey {
+		clientKey[i] ^= signature[i]
+	}
+	serverSignature := hmacSum(h, hmacSum(h, salted, "Server Key"), authMessage)
+	b64 := base64.StdEncoding.EncodeToString
+	return withoutProof + ",p=" + b64(clientKey), "v=" + b64(serverSignature)
+}
+
+func TestScram(t *testing.T) {
+	users := map[string]string{
+		"sha1":   makeVerifier(ScramSha1, "secret"),
+		"sha256": makeVerifier(ScramSha256, "secret"),
+		"a,b=c":  makeVerifier(ScramSha256, "secret"),
+		"plain":  "$sha256$" + "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b",
+	}
+	lookup := func(username string) (string, error) {
+		return users[username], nil
+	}
+	tests := []struct {
+		name     string
+		method   string
+		username string
+		password string
+		header   string
+		binding  string
+		ok       bool
+	}{
+		{"sha1", ScramSha1, "sha1", "secret", "n,,", "n,,", true},
+		{"sha256", ScramSha256, "sha256", "secret", "n,,", "n,,", true},
+		{"escaped username", ScramSha256, "a=2Cb=3Dc", "secret", "n,,", "n,,", true},
+		{"client supports channel binding", ScramSha256, "sha256", "secret", "y,,", "y,,", true},
+		{"wrong password", ScramSha256, "sha256", "wrong", "n,,", "n,,", false},
+		{"method mismatch", ScramSha256, "sha1", "secret", "n,,", "n,,", false},
+		{"not a verifier", ScramSha256, "plain", "secret", "n,,", "n,,", false},
+		{"unknown user", ScramSha256, "nobody", "secret", "n,,", "n,,", false},
+		{"channel binding mismatch", ScramSha256, "sha256", "secret", "n,,", "y,,", false},
+		{"no channel binding", ScramSha256, "sha256", "secret", "y,,", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScram(tt.method, lookup)
+			clientFirst := "n=" + tt.username + ",r=cnonce"
+			serverFirst, done, err := s.Next(tt.header + clientFirst)
+			if err != nil || done {
+				t.Fatalf("client first: %v", err)
+			}
+			if !strings.HasPrefix(serverFirst, "r=cnonce") {
+				t.Fatalf("server nonce %q does not extend client nonce", serverFirst)
+			}
+			final, want := clientFinal(tt.method, tt.password, tt.binding, clientFirst, serverFirst)
+			got, done, err := s.Next(final)
+			if !tt.ok {
+				if err == nil || done {
+					t.Fatalf("client final: want error")
+				}
+				return
+			}
+			if err != nil || !done {
+				t.Fatalf("client final: %v", err)
+			}
+			if got != want {
+				t.Fatalf("server final %q, want %q", got, want)
+			}
+			if _, _, err = s.Next(final); err == nil {
+				t.Fatalf("finished exchange: want error")
+			}
+		})
+	}
+}
+
+// Unknown user gets the same salt in every exchange, like a known user
+func TestScramUnknownUser(t *testing.T) {
+	lookup := func(string) (string, error) { return "", nil }
+	salt := func() string {
+		s := newScram(ScramSha256, lookup)
+		serverFirst, _, err := s.Next("n,,n=nobody,r=cnonce")
+		if err != nil {
+			t.Fatalf("client first: %v", err)
+		}
+		return scramAttrs(serverFirst)['s']
+	}
+	if a, b := salt(), salt(); a != b {
+		t.Fatalf("salt %q, then %q", a, b)
+	}
+}
+
+func TestScramInvalid(t *testing.T) {
+	lookup := func(string) (string, error) { return makeVerifier(ScramSha256, "secret"), nil }
+	tests := []struct {
+		name  string
+		first string
+		final string
+	}{
+		{"channel binding", "p=tls-unique,,n=u,r=cnonce", ""},
+		{"no nonce", "n,,n=u", ""},
+		{"no username", "n,,r=cnonce", ""},
+		{"no proof", "n,,n=u,r=cnonce", "c=biws,r=%s"},
+		{"nonce mismatch", "n,,n=u,r=cnonce", "c=biws,r=other,p=AAAA"},
+		{"bad channel binding", "n,,n=u,r=cnonce", "c=!!,r=%s,p=AAAA"},
+		{"bad proof", "n,,n=u,r=cnonce", "c=biws,r=%s,p=!!"},
+		{"short proof", "n,,n=u,r=cnonce", "c=biws,r=%s,p=AAAA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScram(ScramSha256, lookup)
+			serverFirst, _, err := s.Next(tt.first)
+			if tt.final == "" {
+				if err == nil {
+					t.Fatalf("client first: want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("client first: %v", err)
+			}
+			final := tt.final
+			if strings.Contains(final, "%s") {
+				final = fmt.Sprintf(final, scramAttrs(serverFirst)['r'])
+			}
+			if _, done, err := s.Next(final); err == nil || done {
+				t.Fatalf("client final: want error")
+			}
+		})
+	}
+}
+
+func TestCompareVerifier(t *testing.T) {
+	v := makeVerifier(ScramSha1, "secret")
+	if !compare(v, "secret") {
+		t.Fatalf("password does not match verifier")
+	}
+	if compare(v, "wrong") {
+		t.Fatalf("wrong password matches verifier")
+	}
+}
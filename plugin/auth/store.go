@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"github.com/laomar/gomq/pkg/packets"
+	"github.com/laomar/gomq/server"
+	"github.com/laomar/gomq/store"
+	authstore "github.com/laomar/gomq/store/auth"
+)
+
+const storeName = "auth_store"
+
+// Store authenticator, credentials are kept in the configured store
+type storeAuth struct {
+	store authstore.Store
+}
+
+func init() {
+	server.RegPlugin(storeName, newStore)
+}
+
+func newStore() (server.Plugin, error) {
+	return &storeAuth{}, nil
+}
+
+func (s *storeAuth) Name() string {
+	return storeName
+}
+
+func (s *storeAuth) Load() error {
+	if !enabled(storeName) {
+		return nil
+	}
+	se, err := store.NewStore()
+	if err != nil {
+		return err
+	}
+	s.store, err = se.NewAuthStore()
+	return err
+}
+
+func (s *storeAuth) Unload() error {
+	if s.store == nil {
+		return nil
+	}
+	return s.store.Close()
+}
+
+func (s *storeAuth) AuthMethods() []string {
+	return scramMethods
+}
+
+func (s *storeAuth) NewExchange(c *server.Client, method string) server.AuthExchange {
+	return newScram(method, func(username string) (string, error) {
+		cred, err := s.store.Get(username)
+		if err != nil || cred == nil {
+			return "", err
+		}
+		return cred.Password, nil
+	})
+}
+
+func (s *storeAuth) Authenticate(c *server.Client, pc *packets.Connect) (byte, error) {
+	cred, err := s.store.Get(pc.Username)
+	if err != nil || cred == nil {
+		return server.AuthIgnore, err
+	}
+	return check(cred.Password, true, pc.Password), nil
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/store/topic"
+	"net"
+	"strings"
+)
+
+// Acl action
+const (
+	AclPublish   = "publish"
+	AclSubscribe = "subscribe"
+	AclAll       = "all"
+)
+
+// Topic filters a connection may publish or subscribe, set by authenticator in place of the acl rules
+type ClientAcl struct {
+	Pub []string
+	Sub []string
+}
+
+type aclRule struct {
+	allow    bool
+	action   string
+	username string
+	clientID string
+	ipnet    *net.IPNet
+	topics   []string
+}
+
+// Compile acl rules of config
+func (s *Server) loadAcl() error {
+	rules := make([]*aclRule, 0, len(Cfg.Acl.Rules))
+	for i, r := range Cfg.Acl.Rules {
+		rule := &aclRule{
+			action:   r.Action,
+			username: r.Username,
+			clientID: r.ClientID,
+			topics:   r.Topics,
+		}
+		switch r.Permit {
+		case "allow":
+			rule.allow = true
+		case "deny":
+		default:
+			return fmt.Errorf("acl: rule %d invalid permit %s", i, r.Permit)
+		}
+		switch r.Action {
+		case AclPublish, AclSubscribe, AclAll:
+		case "":
+			rule.action = AclAll
+		default:
+			return fmt.Errorf("acl: rule %d invalid action %s", i, r.Action)
+		}
+		if r.IPAddr != "" {
+			ipnet, err := parseIPNet(r.IPAddr)
+			if err != nil {
+				return fmt.Errorf("acl: rule %d %v", i, err)
+			}
+			rule.ipnet = ipnet
+		}
+		rules = append(rules, rule)
+	}
+	s.acl = rules
+	// cached results of connections are dropped by the new generation
+	s.aclGen.Add(1)
+	return nil
+}
+
+// Parse ip or cidr
+func parseIPNet(addr string) (*net.IPNet, error) {
+	if strings.Contains(addr, "/") {
+		_, ipnet, err := net.ParseCIDR(addr)
+		return ipnet, err
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ip %s", addr)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	bits := len(ip) * 8
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// Check whether client is allowed to publish or subscribe, the first matched rule wins
+func (s *Server) authorize(c *Client, action, name string) bool {
+	if c.acl != nil {
+		return c.acl.Allow(c.ID, c.prop.Username, action, name)
+	}
+	for _, rule := range s.acl {
+		if rule.match(c, action, name) {
+			return rule.allow
+		}
+	}
+	return Cfg.Acl.NoMatch != "deny"
+}
+
+// Allow rules must cover the subscription, deny rules apply once they overlap it
+func (r *aclRule) match(c *Client, action, name string) bool {
+	if r.action != AclAll && r.action != action {
+		return false
+	}
+	if r.username != "" && r.username != c.prop.Username {
+		return false
+	}
+	if r.clientID != "" && r.clientID != c.ID {
+		return false
+	}
+	if r.ipnet != nil && !r.ipnet.Contains(c.ip()) {
+		return false
+	}
+	if len(r.topics) == 0 {
+		return true
+	}
+	for _, filter := range r.topics {
+		filter, err := aclFilter(filter, c.ID, c.prop.Username)
+		if err == errAclWildcard && !r.allow {
+			return true
+		}
+		if err != nil {
+			continue
+		}
+		if action == AclPublish && topic.IsMatch(filter, name) ||
+			action == AclSubscribe && r.allow && coverTopic(filter, name) ||
+			action == AclSubscribe && !r.allow && overlapTopic(filter, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether client of the id and username is allowed to publish or subscribe
+func (a *ClientAcl) Allow(cid, username, action, name string) bool {
+	filters := a.Pub
+	if action == AclSubscribe {
+		filters = a.Sub
+	}
+	for _, filter := range filters {
+		filter, err := aclFilter(filter, cid, username)
+		if err != nil {
+			continue
+		}
+		if action == AclPublish && topic.IsMatch(filter, name) || action == AclSubscribe && coverTopic(filter, name) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	errAclEmpty    = errors.New("acl: empty username or client id")
+	errAclWildcard = errors.New("acl: username or client id holds wildcard or separator")
+)
+
+// Substitute %u and %c of acl filter with username and client id. Values holding + # or / are
+// refused as they widen the filter, client id # would turn devices/%c/# into devices/#
+func aclFilter(filter, cid, username string) (string, error) {
+	olds := make([]string, 0, 4)
+	for _, kv := range [][2]string{{"%u", username}, {"%c", cid}} {
+		if !strings.Contains(filter, kv[0]) {
+			continue
+		}
+		if kv[1] == "" {
+			return "", errAclEmpty
+		}
+		if strings.ContainsAny(kv[1], "+#/") {
+			return "", errAclWildcard
+		}
+		olds = append(olds, kv[0], kv[1])
+	}
+	if len(olds) == 0 {
+		return filter, nil
+	}
+	return strings.NewReplacer(olds...).Replace(filter), nil
+}
+
+// Whether every topic matched by sub is matched by filter
+func coverTopic(filter, sub string) bool {
+	fs := strings.Split(filter, "/")
+	ss := strings.Split(sub, "/")
+	if strings.HasPrefix(ss[0], "$") && (fs[0] == "+" || fs[0] == "#") {
+		return false
+	}
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ss) || ss[i] == "#" || f != "+" && f != ss[i] {
+			return false
+		}
+	}
+	return len(fs) == len(ss)
+}
+
+// Whether some topic is matched by both filter and sub
+func overlapTopic(filter, sub string) bool {
+	fs := strings.Split(filter, "/")
+	ss := strings.Split(sub, "/")
+	if strings.HasPrefix(fs[0], "$") && (ss[0] == "+" || ss[0] == "#") ||
+		strings.HasPrefix(ss[0], "$") && (fs[0] == "+" || fs[0] == "#") {
+		return false
+	}
+	for i := 0; i < len(fs) && i < len(ss); i++ {
+		if fs[i] == "#" || ss[i] == "#" {
+			return true
+		}
+		if fs[i] != "+" && ss[i] != "+" && fs[i] != ss[i] {
+			return false
+		}
+	}
+	// a/# overlaps a
+	if len(fs) == len(ss)+1 && fs[len(fs)-1] == "#" || len(ss) == len(fs)+1 && ss[len(ss)-1] == "#" {
+		return true
+	}
+	return len(fs) == len(ss)
+}
+
+// Check acl with the results cached per connection
+func (c *Client) authorize(action, name string) bool {
+	if gen := c.server.aclGen.Load(); gen != c.aclGen {
+		c.aclCache = make(map[string]bool)
+		c.aclGen = gen
+	}
+	key := action + ":" + name
+	if allow, ok := c.aclCache[key]; ok {
+		return allow
+	}
+	allow := c.server.authorize(c, action, name)
+	if size := Cfg.Acl.CacheSize; size > 0 {
+		if len(c.aclCache) >= size {
+			c.aclCache = make(map[string]bool, size)
+		}
+		c.aclCache[key] = allow
+	}
+	return allow
+}
+
+// Set acl of client
+func (c *Client) SetAcl(acl *ClientAcl) {
+	c.acl = acl
+	c.aclCache = make(map[string]bool)
+}
+
+// IP of client
+func (c *Client) ip() net.IP {
+	host, _, err := net.SplitHostPort(c.prop.IP)
+	if err != nil {
+		host = c.prop.IP
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	. "github.com/laomar/gomq/config"
+	"testing"
+)
+
+func TestCoverTopic(t *testing.T) {
+	tests := []struct {
+		filter string
+		sub    string
+		want   bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/b", "a/+", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/+", true},
+		{"a/+", "a/#", false},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a", true},
+		{"a/#", "a/b/+", true},
+		{"a/#", "a/#", true},
+		{"a/#", "b/#", false},
+		{"#", "a/#", true},
+		{"#", "$SYS/a", false},
+		{"+/a", "$SYS/a", false},
+		{"$SYS/#", "$SYS/a", true},
+		{"a/b/c", "a/b", false},
+	}
+	for _, tt := range tests {
+		if got := coverTopic(tt.filter, tt.sub); got != tt.want {
+			t.Errorf("coverTopic(%q, %q) = %v, want %v", tt.filter, tt.sub, got, tt.want)
+		}
+	}
+}
+
+func TestOverlapTopic(t *testing.T) {
+	tests := []struct {
+		filter string
+		sub    string
+		want   bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+", "+/b", true},
+		{"a/+", "a", false},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a", true},
+		{"a", "a/#", true},
+		{"a/#", "+/b/c", true},
+		{"a/#", "b/#", false},
+		{"#", "a/b", true},
+		{"#", "$SYS/a", false},
+		{"$SYS/a", "+/a", false},
+		{"$SYS/#", "$SYS/+", true},
+		{"a", "a/b", false},
+	}
+	for _, tt := range tests {
+		if got := overlapTopic(tt.filter, tt.sub); got != tt.want {
+			t.Errorf("overlapTopic(%q, %q) = %v, want %v", tt.filter, tt.sub, got, tt.want)
+		}
+		if got := overlapTopic(tt.sub, tt.filter); got != tt.want {
+			t.Errorf("overlapTopic(%q, %q) = %v, want %v", tt.sub, tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestAclFilter(t *testing.T) {
+	tests := []struct {
+		filter   string
+		cid      string
+		username string
+		want     string
+		err      error
+	}{
+		{"devices/%c/#", "d1", "u", "devices/d1/#", nil},
+		{"users/%u/%c", "d1", "u", "users/u/d1", nil},
+		{"users/%u/%c", "%u", "%c", "users/%c/%u", nil},
+		{"a/b", "#", "", "a/b", nil},
+		{"devices/%c/#", "#", "u", "", errAclWildcard},
+		{"devices/%c/#", "+", "u", "", errAclWildcard},
+		{"devices/%c/#", "a/b", "u", "", errAclWildcard},
+		{"users/%u/#", "d1", "", "", errAclEmpty},
+		{"users/%u/#", "d1", "u/#", "", errAclWildcard},
+	}
+	for _, tt := range tests {
+		if got, err := aclFilter(tt.filter, tt.cid, tt.username); got != tt.want || err != tt.err {
+			t.Errorf("aclFilter(%q, %q, %q) = %q %v, want %q %v", tt.filter, tt.cid, tt.username, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	s := &Server{acl: []*aclRule{
+		{allow: false, action: AclAll, topics: []string{"secret/%c/#", "private/%u/#"}},
+		{allow: true, action: AclAll, topics: []string{"devices/%c/#", "users/%u/#"}},
+	}}
+	tests := []struct {
+		name     string
+		cid      string
+		username string
+		action   string
+		topic    string
+		noMatch  string
+		want     bool
+	}{
+		{"own device topic", "d1", "", AclPublish, "devices/d1/t", "deny", true},
+		{"own device filter", "d1", "", AclSubscribe, "devices/d1/#", "deny", true},
+		{"other device", "d1", "", AclPublish, "devices/d2/t", "deny", false},
+		{"wildcard client id publish", "#", "", AclPublish, "devices/d2/t", "deny", false},
+		{"wildcard client id subscribe", "#", "", AclSubscribe, "devices/#", "deny", false},
+		{"single level client id", "+", "", AclSubscribe, "devices/+/t", "deny", false},
+		{"separator client id", "d1/x", "", AclPublish, "devices/d1/x/t", "deny", false},
+		{"own user topic", "d1", "u", AclPublish, "users/u/t", "deny", true},
+		{"anonymous user topic", "d1", "", AclPublish, "users//t", "deny", false},
+		{"wildcard username", "d1", "#", AclSubscribe, "users/#", "deny", false},
+		{"deny rule of wildcard client id", "#", "", AclPublish, "other/t", "allow", false},
+		{"own secret topic", "d1", "", AclPublish, "secret/d1/t", "allow", false},
+		{"deny rule of anonymous user", "d1", "", AclPublish, "other/t", "allow", true},
+		{"own private topic", "d1", "u", AclPublish, "private/u/t", "allow", false},
+	}
+	noMatch := Cfg.Acl.NoMatch
+	defer func() { Cfg.Acl.NoMatch = noMatch }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Cfg.Acl.NoMatch = tt.noMatch
+			c := &Client{ID: tt.cid, prop: &ClientProp{Username: tt.username}}
+			if got := s.authorize(c, tt.action, tt.topic); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
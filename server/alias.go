@@ -0,0 +1,94 @@
+package server
+
+import (
+	"container/list"
+	. "github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/pkg/packets"
+)
+
+// Topic alias mapping of a connection, inbound aliases are set by client and outbound aliases by server
+type topicAlias struct {
+	in  map[uint16]string
+	max uint16
+	out map[string]*list.Element
+	lru *list.List
+}
+
+type aliasEntry struct {
+	topic string
+	alias uint16
+}
+
+func newTopicAlias(max uint16) *topicAlias {
+	return &topicAlias{
+		in:  make(map[uint16]string),
+		max: max,
+		out: make(map[string]*list.Element),
+		lru: list.New(),
+	}
+}
+
+// Resolve topic name of inbound publish, returns false when the alias is out of range or unknown
+func (a *topicAlias) resolve(pp *packets.Publish) bool {
+	if pp.Properties == nil || pp.Properties.TopicAlias == nil {
+		return true
+	}
+	alias := *pp.Properties.TopicAlias
+	if alias == 0 || alias > Cfg.Mqtt.MaxTopicAlias {
+		return false
+	}
+	if pp.TopicName == "" {
+		topic, ok := a.in[alias]
+		if !ok {
+			return false
+		}
+		pp.TopicName = topic
+	} else {
+		a.in[alias] = pp.TopicName
+	}
+	// the alias only makes sense on this connection
+	props := *pp.Properties
+	props.TopicAlias = nil
+	pp.Properties = &props
+	return true
+}
+
+// Allocate outbound alias of topic, the least recently used alias is replaced when all are taken.
+// Returns whether the alias is already known to client.
+func (a *topicAlias) alloc(topic string) (uint16, bool) {
+	if e, ok := a.out[topic]; ok {
+		a.lru.MoveToFront(e)
+		return e.Value.(*aliasEntry).alias, true
+	}
+	var ae *aliasEntry
+	if a.lru.Len() < int(a.max) {
+		ae = &aliasEntry{alias: uint16(a.lru.Len() + 1)}
+	} else {
+		e := a.lru.Back()
+		ae = e.Value.(*aliasEntry)
+		delete(a.out, ae.topic)
+		a.lru.Remove(e)
+	}
+	ae.topic = topic
+	a.out[topic] = a.lru.PushFront(ae)
+	return ae.alias, false
+}
+
+// Copy outbound publish with topic alias, the topic name is omitted when client knows the alias
+func (a *topicAlias) assign(pp *packets.Publish) *packets.Publish {
+	if a.max == 0 {
+		return pp
+	}
+	np := *pp
+	props := packets.Properties{}
+	if pp.Properties != nil {
+		props = *pp.Properties
+	}
+	alias, known := a.alloc(pp.TopicName)
+	props.TopicAlias = &alias
+	if known {
+		np.TopicName = ""
+	}
+	np.Properties = &props
+	return &np
+}
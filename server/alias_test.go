@@ -0,0 +1,94 @@
+package server
+
+import (
+	. "github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/pkg/packets"
+	"testing"
+)
+
+func TestTopicAliasAlloc(t *testing.T) {
+	type alloc struct {
+		topic string
+		alias uint16
+		known bool
+	}
+	tests := []struct {
+		name   string
+		max    uint16
+		allocs []alloc
+	}{
+		{"new and known", 2, []alloc{{"a", 1, false}, {"b", 2, false}, {"a", 1, true}, {"b", 2, true}}},
+		{"least recently used is replaced", 2, []alloc{{"a", 1, false}, {"b", 2, false}, {"a", 1, true}, {"c", 2, false}, {"b", 1, false}, {"c", 2, true}}},
+		{"single alias", 1, []alloc{{"a", 1, false}, {"b", 1, false}, {"a", 1, false}, {"a", 1, true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTopicAlias(tt.max)
+			for _, al := range tt.allocs {
+				alias, known := a.alloc(al.topic)
+				if alias != al.alias || known != al.known {
+					t.Fatalf("alloc %q: got %d %v, want %d %v", al.topic, alias, known, al.alias, al.known)
+				}
+			}
+		})
+	}
+}
+
+func TestTopicAliasAssign(t *testing.T) {
+	a := newTopicAlias(0)
+	pp := testPublish(0)
+	if np := a.assign(pp); np != pp {
+		t.Fatalf("publish is copied without topic alias maximum")
+	}
+
+	a = newTopicAlias(1)
+	first := a.assign(pp)
+	second := a.assign(pp)
+	if first.TopicName != "a/b" || *first.Properties.TopicAlias != 1 {
+		t.Fatalf("first publish got %q alias %d", first.TopicName, *first.Properties.TopicAlias)
+	}
+	if second.TopicName != "" || *second.Properties.TopicAlias != 1 {
+		t.Fatalf("second publish got %q alias %d", second.TopicName, *second.Properties.TopicAlias)
+	}
+	if pp.TopicName != "a/b" || pp.Properties != nil {
+		t.Fatalf("original publish is changed")
+	}
+}
+
+func TestTopicAliasResolve(t *testing.T) {
+	alias := func(topic string, alias uint16) *packets.Publish {
+		pp := testPublish(0)
+		pp.TopicName = topic
+		pp.Properties = &packets.Properties{TopicAlias: &alias}
+		return pp
+	}
+	tests := []struct {
+		name  string
+		pp    *packets.Publish
+		ok    bool
+		topic string
+	}{
+		{"no alias", testPublish(0), true, "a/b"},
+		{"set alias", alias("x/y", 1), true, "x/y"},
+		{"use alias", alias("", 1), true, "x/y"},
+		{"reset alias", alias("z", 1), true, "z"},
+		{"use reset alias", alias("", 1), true, "z"},
+		{"unknown alias", alias("", 2), false, ""},
+		{"zero alias", alias("a", 0), false, "a"},
+		{"alias over maximum", alias("a", 11), false, "a"},
+	}
+	max := Cfg.Mqtt.MaxTopicAlias
+	defer func() { Cfg.Mqtt.MaxTopicAlias = max }()
+	Cfg.Mqtt.MaxTopicAlias = 10
+	a := newTopicAlias(0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ok := a.resolve(tt.pp); ok != tt.ok || tt.pp.TopicName != tt.topic {
+				t.Fatalf("got %v %q, want %v %q", ok, tt.pp.TopicName, tt.ok, tt.topic)
+			}
+			if tt.ok && tt.pp.Properties != nil && tt.pp.Properties.TopicAlias != nil {
+				t.Fatalf("resolved alias is forwarded")
+			}
+		})
+	}
+}
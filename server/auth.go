@@ -0,0 +1,128 @@
+package server
+
+import (
+	"fmt"
+	"github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/log"
+	"github.com/laomar/gomq/pkg/packets"
+	"time"
+)
+
+// Authentication result
+const (
+	AuthIgnore = iota // leave it to the next authenticator
+	AuthAllow
+	AuthDeny
+)
+
+// Authenticator plugin checks the credential of connecting client
+type Authenticator interface {
+	Plugin
+	Authenticate(c *Client, pc *packets.Connect) (byte, error)
+}
+
+// Exchange of enhanced authentication
+type AuthExchange interface {
+	// Next takes auth data of client, returns auth data for client and whether authentication is done
+	Next(data string) (string, bool, error)
+	// Username authenticated by the exchange
+	Username() string
+}
+
+// Enhanced authenticator plugin handles the auth methods of v5 client
+type EnhancedAuthenticator interface {
+	Authenticator
+	AuthMethods() []string
+	NewExchange(c *Client, method string) AuthExchange
+}
+
+// Load authenticators in the configured order
+func (s *Server) loadAuth() error {
+	auths := make([]Authenticator, 0, len(config.Cfg.Auth.Chain))
+	methods := make(map[string]EnhancedAuthenticator)
+	for _, name := range config.Cfg.Auth.Chain {
+		auth, ok := plugins[name].(Authenticator)
+		if !ok {
+			return fmt.Errorf("auth: authenticator %s not found", name)
+		}
+		auths = append(auths, auth)
+		if ea, ok := auth.(EnhancedAuthenticator); ok {
+			for _, method := range ea.AuthMethods() {
+				if _, ok := methods[method]; !ok {
+					methods[method] = ea
+				}
+			}
+		}
+	}
+	s.auths = auths
+	s.authMethods = methods
+	return nil
+}
+
+// Authenticate client through the authenticator chain, the first allow or deny wins
+func (s *Server) authenticate(c *Client, pc *packets.Connect) byte {
+	if len(s.auths) == 0 {
+		return packets.Success
+	}
+	for _, auth := range s.auths {
+		result, err := auth.Authenticate(c, pc)
+		if err != nil {
+			log.Errorf("auth: %s %v cid=%s", auth.Name(), err, pc.ClientID)
+			continue
+		}
+		switch result {
+		case AuthAllow:
+			return packets.Success
+		case AuthDeny:
+			log.Debugf("auth: %s denied cid=%s user=%s", auth.Name(), pc.ClientID, pc.Username)
+			return packets.BadUserNameOrPassword
+		}
+	}
+	if config.Cfg.Auth.NoMatch == "allow" {
+		return packets.Success
+	}
+	log.Debugf("auth: no match cid=%s user=%s", pc.ClientID, pc.Username)
+	return packets.BadUserNameOrPassword
+}
+
+// Disconnect client when its credential expires
+func (c *Client) ExpireAt(t time.Time) {
+	if c.expireTimer != nil {
+		c.expireTimer.Stop()
+	}
+	c.expireTimer = time.AfterFunc(time.Until(t), func() {
+		log.Debugf("auth: credential expired cid=%s", c.ID)
+		c.Kick(packets.NotAuthorized, nil)
+	})
+}
+
+// Start enhanced authentication, returns reason code and auth data for client
+func (c *Client) startAuth(method, data string) (byte, string) {
+	ea, ok := c.server.authMethods[method]
+	if !ok {
+		log.Debugf("auth: bad auth method cid=%s method=%s", c.ID, method)
+		return packets.BadAuthMethod, ""
+	}
+	c.authMethod = method
+	c.authExchange = ea.NewExchange(c, method)
+	return c.stepAuth(data)
+}
+
+// Step enhanced authentication
+func (c *Client) stepAuth(data string) (byte, string) {
+	ex := c.authExchange
+	resp, done, err := ex.Next(data)
+	if err != nil {
+		log.Debugf("auth: %s %v cid=%s", c.authMethod, err, c.ID)
+		c.authExchange = nil
+		return packets.NotAuthorized, ""
+	}
+	if !done {
+		return packets.ContinueAuthentication, resp
+	}
+	c.authExchange = nil
+	if username := ex.Username(); username != "" {
+		c.prop.Username = username
+	}
+	return packets.Success, resp
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"errors"
+	. "github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/pkg/packets"
+	"testing"
+)
+
+// Authenticator of tests returning a fixed result
+type testAuth struct {
+	name   string
+	result byte
+	err    error
+	called int
+}
+
+func (a *testAuth) Name() string  { return a.name }
+func (a *testAuth) Load() error   { return nil }
+func (a *testAuth) Unload() error { return nil }
+
+func (a *testAuth) Authenticate(*Client, *packets.Connect) (byte, error) {
+	a.called++
+	return a.result, a.err
+}
+
+func TestAuthChain(t *testing.T) {
+	errAuth := errors.New("auth failed")
+	tests := []struct {
+		name    string
+		chain   []*testAuth
+		noMatch string
+		version byte
+		want    byte
+		called  []int
+	}{
+		{"empty chain", nil, "deny", packets.V5, packets.Success, nil},
+		{"allow", []*testAuth{{result: AuthAllow}, {result: AuthDeny}}, "deny", packets.V5, packets.Success, []int{1, 0}},
+		{"deny", []*testAuth{{result: AuthDeny}, {result: AuthAllow}}, "allow", packets.V5, packets.BadUserNameOrPassword, []int{1, 0}},
+		{"deny v3", []*testAuth{{result: AuthDeny}}, "allow", packets.V311, packets.RefusedBadUsernameOrPassword, []int{1}},
+		{"ignore to allow", []*testAuth{{result: AuthIgnore}, {result: AuthAllow}}, "deny", packets.V5, packets.Success, []int{1, 1}},
+		{"error to next", []*testAuth{{result: AuthDeny, err: errAuth}, {result: AuthAllow}}, "deny", packets.V5, packets.Success, []int{1, 1}},
+		{"no match allow", []*testAuth{{result: AuthIgnore}}, "allow", packets.V5, packets.Success, []int{1}},
+		{"no match deny", []*testAuth{{result: AuthIgnore}}, "deny", packets.V5, packets.BadUserNameOrPassword, []int{1}},
+		{"no match deny v3", []*testAuth{{result: AuthIgnore}}, "deny", packets.V311, packets.RefusedBadUsernameOrPassword, []int{1}},
+	}
+	noMatch := Cfg.Auth.NoMatch
+	defer func() { Cfg.Auth.NoMatch = noMatch }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Cfg.Auth.NoMatch = tt.noMatch
+			s := testServer(t)
+			for i, auth := range tt.chain {
+				auth.name = string(rune('a' + i))
+				s.auths = append(s.auths, auth)
+			}
+			_, ack := testConnect(t, s, "c", func(pc *packets.Connect) { pc.Version = tt.version })
+			if ack.ReasonCode != tt.want {
+				t.Fatalf("got %#x, want %#x", ack.ReasonCode, tt.want)
+			}
+			for i, auth := range tt.chain {
+				if auth.called != tt.called[i] {
+					t.Fatalf("authenticator %d called %d, want %d", i, auth.called, tt.called[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLoadAuth(t *testing.T) {
+	chain := Cfg.Auth.Chain
+	defer func() { Cfg.Auth.Chain = chain }()
+	plugins["test-auth"] = &testAuth{name: "test-auth"}
+	defer delete(plugins, "test-auth")
+
+	s := testServer(t)
+	Cfg.Auth.Chain = []string{"test-auth"}
+	if err := s.loadAuth(); err != nil || len(s.auths) != 1 {
+		t.Fatalf("got %d authenticators %v, want 1", len(s.auths), err)
+	}
+	Cfg.Auth.Chain = []string{"test-auth", "missing"}
+	if err := s.loadAuth(); err == nil {
+		t.Fatal("got nil, want error of missing authenticator")
+	}
+}
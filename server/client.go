@@ -1,14 +1,21 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"github.com/google/uuid"
 	. "github.com/laomar/gomq/config"
 	"github.com/laomar/gomq/log"
 	"github.com/laomar/gomq/pkg/packets"
+	"github.com/laomar/gomq/store/queue"
+	"github.com/laomar/gomq/store/topic"
 	"math"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -28,36 +35,62 @@ type ClientProp struct {
 	Protocol              string
 	CleanStart            bool
 	IP                    string
+	WillDelayInterval     uint32
 }
 type Client struct {
-	ctx     context.Context
-	cancel  context.CancelFunc
-	server  *Server
-	conn    net.Conn
-	ID      string
-	ConnAt  int64
-	Version byte
-	Status  byte
-	in      chan packets.Packet
-	out     chan packets.Packet
-	prop    *ClientProp
+	ctx          context.Context
+	cancel       context.CancelFunc
+	server       *Server
+	conn         net.Conn
+	ID           string
+	ConnAt       int64
+	Version      byte
+	Status       byte
+	in           chan packets.Packet
+	out          chan packets.Packet
+	prop         *ClientProp
+	session      *session
+	will         *packets.Publish
+	alias        *topicAlias
+	aclCache     map[string]bool
+	aclGen       uint64
+	authMethod   string
+	authExchange AuthExchange
+	acl          *ClientAcl
+	listener     string
+	expireTimer  *time.Timer
+	reason       byte
+	inbound      atomic.Int32 // inbound qos 1 and 2 messages not yet acknowledged
+	readTimeout  atomic.Int64 // 1.5 times keep alive, read by the read loop while connecting
+	once         sync.Once
+	done         chan struct{} // closed when the client is closed and no packet is handled any more
 }
 
 func (c *Client) serve() {
+	defer close(c.done)
 	go c.readLoop()
 	if c.connect() {
 		go c.writeLoop()
-		go c.handleLoop()
+		c.resend()
+		c.handleLoop()
 	}
 	<-c.ctx.Done()
 }
 
 // Read packet
 func (c *Client) readPacket() (packets.Packet, error) {
+	if pc, ok := c.conn.(packetConn); ok {
+		return pc.ReadPacket()
+	}
 	var fh packets.FixHeader
 	if err := fh.Unpack(c.conn); err != nil {
 		return nil, err
 	}
+	// refuse before allocating the packet body
+	if max := Cfg.Mqtt.MaximumPacketSize; max > 0 && fh.Size() > int(max) {
+		c.server.stats.RefusedTooLarge.Add(1)
+		return nil, packets.ErrTooLarge
+	}
 	p := packets.NewPacket(&fh, c.Version)
 	if p == nil {
 		return p, packets.ErrProtocol
@@ -66,9 +99,51 @@ func (c *Client) readPacket() (packets.Packet, error) {
 	return p, err
 }
 
-// Write packet
+// Write packet, publish exceeding the maximum packet size of client is discarded
 func (c *Client) writePacket(p packets.Packet) error {
-	return p.Pack(c.conn)
+	pc, isPacketConn := c.conn.(packetConn)
+	pp, ok := p.(*packets.Publish)
+	if !ok {
+		if isPacketConn {
+			return pc.WritePacket(p)
+		}
+		return p.Pack(c.conn)
+	}
+	buf := &bytes.Buffer{}
+	if err := pp.Pack(buf); err != nil {
+		return err
+	}
+	max := int(c.prop.MaximumPacketSize)
+	if max > 0 && buf.Len() > max {
+		c.discard(pp, buf.Len())
+		return nil
+	}
+	if isPacketConn {
+		return pc.WritePacket(pp)
+	}
+	// topic alias takes at most 5 more bytes
+	if c.alias != nil && (max == 0 || buf.Len()+5 <= max) {
+		buf.Reset()
+		if err := c.alias.assign(pp).Pack(buf); err != nil {
+			return err
+		}
+	}
+	_, err := buf.WriteTo(c.conn)
+	return err
+}
+
+// Discard publish as if it is acknowledged
+func (c *Client) discard(pp *packets.Publish, size int) {
+	log.Debugf("publish: packet too large cid=%s topic=%s size=%d", c.ID, pp.TopicName, size)
+	c.server.stats.DroppedTooLarge.Add(1)
+	c.drop(pp)
+}
+
+// Remove publish from inflight window, the queued messages take its place
+func (c *Client) drop(pp *packets.Publish) {
+	if pp.FixHeader.Qos > packets.Qos0 && c.session.inflight.remove(pp.PacketID) {
+		go c.resume()
+	}
 }
 
 func (c *Client) readLoop() {
@@ -83,20 +158,44 @@ func (c *Client) readLoop() {
 		case <-c.ctx.Done():
 			return
 		default:
-			if ka := c.prop.KeepAlive; ka > 0 {
-				_ = c.conn.SetReadDeadline(time.Now().Add(time.Second * time.Duration(ka/2+ka)))
-			}
+			c.keepAlive()
 			p, err := c.readPacket()
+			if err == packets.ErrTooLarge {
+				log.Debugf("client: packet too large cid=%s", c.ID)
+				c.Kick(packets.PacketTooLarge, nil)
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && c.Status == Connected {
+				log.Debugf("client: keep alive timeout cid=%s keepalive=%d", c.ID, c.prop.KeepAlive)
+				c.server.stats.KeepAliveTimeout.Add(1)
+				c.Kick(packets.KeepAliveTimeout, nil)
+				return
+			}
 			if err != nil {
 				log.Debugf("client: %v", err)
 				c.close()
 				return
 			}
+			if pp, ok := p.(*packets.Publish); ok && pp.FixHeader.Qos > packets.Qos0 {
+				n := c.inbound.Add(1)
+				if c.Version == packets.V5 && Cfg.Mqtt.ReceiveMaximum > 0 && n > int32(Cfg.Mqtt.ReceiveMaximum) {
+					log.Debugf("client: receive maximum exceeded cid=%s inbound=%d", c.ID, n)
+					c.Kick(packets.RecvMaxExceeded, nil)
+					return
+				}
+			}
 			c.in <- p
 		}
 	}
 }
 
+// Extend read deadline to 1.5 times keep alive
+func (c *Client) keepAlive() {
+	if d := c.readTimeout.Load(); d > 0 {
+		_ = c.conn.SetReadDeadline(time.Now().Add(time.Duration(d)))
+	}
+}
+
 func (c *Client) writeLoop() {
 	defer func() {
 		if r := recover(); r != nil {
@@ -114,6 +213,21 @@ func (c *Client) writeLoop() {
 				c.close()
 				return
 			}
+			switch p := out.(type) {
+			case *packets.Disconnect:
+				c.close()
+				return
+			case *packets.Puback:
+				c.inbound.Add(-1)
+			case *packets.Pubrec:
+				if p.ReasonCode >= packets.UnspecifiedError {
+					c.inbound.Add(-1)
+				}
+			case *packets.Pubcomp:
+				if p.ReasonCode == packets.Success {
+					c.inbound.Add(-1)
+				}
+			}
 		}
 	}
 }
@@ -132,8 +246,14 @@ func (c *Client) handleLoop() {
 			c.pingReqHandler()
 		case *packets.Publish:
 			c.publishHandler(p)
+		case *packets.Puback:
+			c.puback(p)
+		case *packets.Pubrec:
+			c.pubrec(p)
 		case *packets.Pubrel:
 			c.pubrel(p)
+		case *packets.Pubcomp:
+			c.pubcomp(p)
 		case *packets.Subscribe:
 			c.subscribeHandler(p)
 		case *packets.Unsubscribe:
@@ -144,40 +264,178 @@ func (c *Client) handleLoop() {
 	}
 }
 
+// Deliver packet to client
+func (c *Client) deliver(p packets.Packet) {
+	select {
+	case c.out <- p:
+	case <-c.ctx.Done():
+	}
+}
+
+// Copy publish message for delivery, qos is downgraded to the message qos,
+// subids are the identifiers of the matched subscriptions
+func newPublish(pp *packets.Publish, qos byte, retain bool, subids ...uint32) *packets.Publish {
+	if qos > pp.FixHeader.Qos {
+		qos = pp.FixHeader.Qos
+	}
+	// only the publish properties of publisher are forwarded
+	var props *packets.Properties
+	if p := pp.Properties; p != nil || len(subids) > 0 {
+		props = &packets.Properties{SubscriptionIdentifier: subids}
+		if p != nil {
+			props.PayloadFormat = p.PayloadFormat
+			props.MessageExpiry = p.MessageExpiry
+			props.ContentType = p.ContentType
+			props.ResponseTopic = p.ResponseTopic
+			props.CorrelationData = p.CorrelationData
+			props.User = p.User
+		}
+	}
+	return &packets.Publish{
+		FixHeader: &packets.FixHeader{
+			PacketType: packets.PUBLISH,
+			Qos:        qos,
+			Retain:     retain,
+		},
+		TopicName:  pp.TopicName,
+		Properties: props,
+		Payload:    pp.Payload,
+	}
+}
+
+// Identifiers of subscriptions, subscriptions without identifier are skipped
+func subIDs(subs ...*packets.Subscription) []uint32 {
+	ids := make([]uint32, 0, len(subs))
+	for _, sub := range subs {
+		if sub.SubID > 0 {
+			ids = append(ids, sub.SubID)
+		}
+	}
+	return ids
+}
+
+// Publish message to client, share is the shared topic filter the message is dispatched by,
+// at is the expiry time of message
+func (c *Client) publish(pp *packets.Publish, share string, at int64) {
+	if !remainExpiry(pp, at) {
+		log.Debugf("publish: message expired cid=%s topic=%s", c.ID, pp.TopicName)
+		c.server.stats.DroppedExpired.Add(1)
+		return
+	}
+	pp.Version = c.Version
+	if pp.FixHeader.Qos > packets.Qos0 {
+		ok, err := c.session.inflight.push(pp, share, at)
+		if err != nil {
+			log.Debugf("queue: %v cid=%s topic=%s", err, c.ID, pp.TopicName)
+			if err == queue.ErrDropped && Cfg.Mqtt.QueueDropPolicy == queue.DropDisconnect {
+				go c.Kick(packets.QuotaExceeded, &packets.Properties{ReasonString: "message queue is full"})
+			}
+		}
+		if !ok {
+			return
+		}
+	}
+	c.deliver(pp)
+}
+
+// Send disconnect with reason code and properties to v5 client and close the connection,
+// props may carry reason string or server reference for use another server and server moved
+func (c *Client) Kick(code byte, props *packets.Properties) {
+	c.reason = code
+	if c.Version != packets.V5 || c.Status != Connected {
+		c.close()
+		return
+	}
+	select {
+	case c.out <- &packets.Disconnect{
+		Version:    c.Version,
+		ReasonCode: code,
+		Properties: props,
+	}:
+	case <-c.ctx.Done():
+	case <-time.After(time.Second):
+		// the write loop is stuck, give up the disconnect packet
+		c.close()
+	}
+}
+
 // Client close
 func (c *Client) close() {
-	defer c.cancel()
-	if c.conn != nil {
-		c.conn.Close()
-	}
-	//c.server.topicStore.UnsubscribeAll(c.ID)
+	c.once.Do(func() {
+		defer c.cancel()
+		if c.expireTimer != nil {
+			c.expireTimer.Stop()
+		}
+		if c.conn != nil {
+			c.conn.Close()
+		}
+		if c.ID != "" {
+			c.server.clients.CompareAndDelete(c.ID, c)
+		}
+		if c.session != nil {
+			// the connection taking over the session resends its messages
+			if c.reason != packets.SessionTakenOver {
+				c.server.redispatch(c.session)
+			}
+			c.server.closeSession(c)
+		}
+	})
 }
 
 // Handle connect
 func (c *Client) connect() bool {
 	var pc *packets.Connect
+	var assigned bool
 	for in := range c.in {
 		var code byte
+		var data string
 		switch p := in.(type) {
 		case *packets.Connect:
+			if pc != nil {
+				code = packets.ProtocolError
+				break
+			}
 			pc = p
 			c.Version = pc.Version
+			c.peerIdentity(pc)
+			// empty client id is assigned by server, except for v3.1 and v3.1.1 persistent session
 			if len(pc.ClientID) == 0 {
-				code = packets.ClientIdentifierNotValid
+				if c.Version == packets.V31 || c.Version == packets.V311 && !pc.CleanStart {
+					code = packets.ClientIdentifierNotValid
+					break
+				}
+				pc.ClientID = assignClientID()
+				assigned = true
+			}
+			if code = checkWill(pc); code != packets.Success {
+				break
+			}
+			if c.Version == packets.V5 && pc.Properties.AuthMethod != "" {
+				code, data = c.startAuth(pc.Properties.AuthMethod, pc.Properties.AuthData)
 				break
 			}
 			code = c.connectHandler(pc)
 		case *packets.Auth:
+			if pc == nil || c.authExchange == nil || p.ReasonCode != packets.ContinueAuthentication ||
+				p.Properties.AuthMethod != c.authMethod {
+				code = packets.ProtocolError
+				break
+			}
+			code, data = c.stepAuth(p.Properties.AuthData)
 		default:
 			code = packets.MalformedPacket
 		}
+		if pc == nil {
+			return false
+		}
 
 		// continue authentication
 		if code == packets.ContinueAuthentication {
 			auth := &packets.Auth{
 				ReasonCode: code,
 				Properties: &packets.Properties{
-					AuthMethod: pc.Properties.AuthMethod,
+					AuthMethod: c.authMethod,
+					AuthData:   data,
 				},
 			}
 			_ = c.writePacket(auth)
@@ -186,7 +444,10 @@ func (c *Client) connect() bool {
 
 		ack := &packets.Connack{
 			Version:    c.Version,
-			ReasonCode: code,
+			ReasonCode: packets.Code(c.Version, code),
+		}
+		if c.Version != packets.V5 && ack.ReasonCode > packets.RefusedNotAuthorised {
+			ack.ReasonCode = packets.RefusedServerUnavailable
 		}
 
 		// connect fail
@@ -198,20 +459,20 @@ func (c *Client) connect() bool {
 		// connect success
 		c.Status = Connected
 		c.ID = pc.ClientID
-		c.prop.Username = pc.Username
+		if c.prop.Username == "" {
+			c.prop.Username = pc.Username
+		}
 		c.prop.Protocol = pc.Protocol
 		c.prop.CleanStart = pc.CleanStart
 		c.prop.IP = c.conn.RemoteAddr().String()
 		c.ConnAt = time.Now().Unix()
 		c.prop.MaxInflight = Cfg.Mqtt.MaxInflight
+		c.will = newWill(pc)
 
+		c.prop.KeepAlive = keepAlive(pc.KeepAlive)
+		c.readTimeout.Store(int64(time.Duration(c.prop.KeepAlive) * time.Second * 3 / 2))
 		if c.Version == packets.V5 {
-			c.prop.KeepAlive = Cfg.Mqtt.ServerKeepAlive
-			if pc.KeepAlive < c.prop.KeepAlive || c.prop.KeepAlive == 0 {
-				c.prop.KeepAlive = pc.KeepAlive
-			}
-
-			if rm := pc.Properties.ReceiveMaximum; rm != nil {
+			if rm := pc.Properties.ReceiveMaximum; rm != nil && (*rm < c.prop.MaxInflight || c.prop.MaxInflight == 0) {
 				c.prop.MaxInflight = *rm
 			}
 
@@ -232,6 +493,12 @@ func (c *Client) connect() bool {
 				c.prop.TopicAliasMaximum = *tam
 			}
 
+			c.alias = newTopicAlias(c.prop.TopicAliasMaximum)
+
+			if pc.WillFlag && pc.WillProperties.WillDelayInterval != nil {
+				c.prop.WillDelayInterval = *pc.WillProperties.WillDelayInterval
+			}
+
 			ack.Properties = &packets.Properties{
 				RetainAvailable:       boolToByte(Cfg.Mqtt.RetainAvailable),
 				SessionExpiryInterval: &c.prop.SessionExpiryInterval,
@@ -243,13 +510,31 @@ func (c *Client) connect() bool {
 				WildcardSubAvailable:  boolToByte(Cfg.Mqtt.WildcardSub),
 				SubIDAvailable:        boolToByte(Cfg.Mqtt.SubID),
 				SharedSubAvailable:    boolToByte(Cfg.Mqtt.SharedSub),
+				AuthMethod:            c.authMethod,
+				AuthData:              data,
+			}
+			if assigned {
+				ack.Properties.AssignedClientID = c.ID
+			}
+			if rri := pc.Properties.RequestResponseInfo; rri != nil && *rri == 1 && Cfg.Mqtt.ResponseInfo != "" {
+				ack.Properties.ResponseInfo = strings.NewReplacer("%c", c.ID, "%u", c.prop.Username).Replace(Cfg.Mqtt.ResponseInfo)
 			}
 		} else {
-			c.prop.KeepAlive = pc.KeepAlive
+			if !pc.CleanStart {
+				c.prop.SessionExpiryInterval = Cfg.Mqtt.SessionExpiryInterval
+			}
 		}
 
+		if present := c.server.openSession(c); present && c.Version != packets.V31 {
+			ack.SessionPresent = true
+		}
+		// qos 2 messages of the session waiting for pubrel count towards receive maximum
+		c.inbound.Store(int32(c.session.received.len()))
 		err := c.writePacket(ack)
 		if err == nil {
+			// the pending read of readLoop is waiting without deadline
+			c.keepAlive()
+			c.server.clients.Store(c.ID, c)
 			log.Debugf("mqtt: connected cid=%s addr=%s", c.ID, c.conn.RemoteAddr())
 			return true
 		}
@@ -258,8 +543,37 @@ func (c *Client) connect() bool {
 	return false
 }
 
+// Client id assigned by server, node name keeps it unique in cluster
+func assignClientID() string {
+	id := strings.ReplaceAll(uuid.NewString(), "-", "")
+	if Cfg.NodeName != "" {
+		id = Cfg.NodeName + "-" + id
+	}
+	return Cfg.Mqtt.ClientIDPrefix + id
+}
+
+// Keep alive of client, limited by server keep alive and clamped to min and max keep alive.
+// v5 client is told by server keep alive of connack, v3 client is only timed out by it
+func keepAlive(ka uint16) uint16 {
+	if ska := Cfg.Mqtt.ServerKeepAlive; ska > 0 && (ka == 0 || ka > ska) {
+		ka = ska
+	}
+	if min := Cfg.Mqtt.MinKeepAlive; min > 0 && ka < min {
+		ka = min
+	}
+	if max := Cfg.Mqtt.MaxKeepAlive; max > 0 && (ka == 0 || ka > max) {
+		ka = max
+	}
+	return ka
+}
+
 func (c *Client) connectHandler(pc *packets.Connect) byte {
-	return packets.Success
+	return c.server.authenticate(c, pc)
+}
+
+// Remote address of client
+func (c *Client) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
 }
 
 func boolToByte(b bool) *byte {
@@ -274,12 +588,56 @@ func boolToByte(b bool) *byte {
 
 // Handle disconnect
 func (c *Client) disconnect(pd *packets.Disconnect) {
+	c.reason = pd.ReasonCode
+	if pd.ReasonCode != packets.DisconnectWithWillMessage {
+		c.will = nil
+	}
+	if pd.Properties != nil && pd.Properties.SessionExpiryInterval != nil && c.prop.SessionExpiryInterval > 0 {
+		c.prop.SessionExpiryInterval = *pd.Properties.SessionExpiryInterval
+	}
 	c.close()
 }
 
 // Handle publish
 func (c *Client) publishHandler(pp *packets.Publish) {
 	if !Cfg.Mqtt.RetainAvailable && pp.FixHeader.Retain {
+		log.Debugf("publish: retain not supported cid=%s topic=%s", c.ID, pp.TopicName)
+		c.Kick(packets.RetainNotSupported, nil)
+		return
+	}
+
+	if c.alias != nil && !c.alias.resolve(pp) {
+		log.Debugf("publish: invalid topic alias cid=%s topic=%s", c.ID, pp.TopicName)
+		c.Kick(packets.TopicAliasInvalid, nil)
+		return
+	}
+
+	if pp.TopicName == "" || strings.ContainsAny(pp.TopicName, "+#") {
+		log.Debugf("publish: invalid topic cid=%s topic=%s", c.ID, pp.TopicName)
+		c.close()
+		return
+	}
+
+	// payload of utf-8 format is checked, qos 0 message can only be refused by disconnect
+	if pf := pp.Properties; pf != nil && pf.PayloadFormat != nil && *pf.PayloadFormat == 1 && !utf8.Valid(pp.Payload) {
+		log.Debugf("publish: payload format invalid cid=%s topic=%s", c.ID, pp.TopicName)
+		if pp.FixHeader.Qos == packets.Qos0 {
+			c.Kick(packets.PayloadFormatInvalid, nil)
+			return
+		}
+		c.refuse(pp, packets.PayloadFormatInvalid)
+		return
+	}
+
+	// v5 client is told by puback or pubrec, v3 client is disconnected
+	if !c.authorize(AclPublish, pp.TopicName) {
+		log.Debugf("publish: not authorized cid=%s topic=%s", c.ID, pp.TopicName)
+		if c.Version != packets.V5 {
+			c.close()
+			return
+		}
+		c.refuse(pp, packets.NotAuthorized)
+		return
 	}
 
 	switch pp.FixHeader.Qos {
@@ -290,25 +648,124 @@ func (c *Client) publishHandler(pp *packets.Publish) {
 			ReasonCode: packets.Success,
 			PacketID:   pp.PacketID,
 		}
-		_ = ack.Pack(c.conn)
+		c.deliver(ack)
 	case packets.Qos2:
+		// route on pubrel, a duplicate publish only gets the pubrec again
 		rec := &packets.Pubrec{
 			Version:    c.Version,
 			ReasonCode: packets.Success,
 			PacketID:   pp.PacketID,
 		}
-		_ = rec.Pack(c.conn)
+		if !c.session.received.store(pp) {
+			if pp.FixHeader.Dup {
+				// the duplicate is counted by the stored message
+				c.inbound.Add(-1)
+			} else {
+				rec.ReasonCode = packets.PacketIDInUse
+			}
+		}
+		c.deliver(rec)
+		return
+	}
+
+	c.server.publish(c.ID, pp)
+}
+
+// Refuse publish with reason code of puback or pubrec
+func (c *Client) refuse(pp *packets.Publish, code byte) {
+	switch pp.FixHeader.Qos {
+	case packets.Qos1:
+		c.deliver(&packets.Puback{
+			Version:    c.Version,
+			ReasonCode: code,
+			PacketID:   pp.PacketID,
+		})
+	case packets.Qos2:
+		c.deliver(&packets.Pubrec{
+			Version:    c.Version,
+			ReasonCode: code,
+			PacketID:   pp.PacketID,
+		})
 	}
 }
 
 // Handle pubrel
 func (c *Client) pubrel(pp *packets.Pubrel) {
-	rec := &packets.Pubcomp{
+	comp := &packets.Pubcomp{
 		Version:    c.Version,
 		ReasonCode: packets.Success,
 		PacketID:   pp.PacketID,
 	}
-	_ = rec.Pack(c.conn)
+	if msg := c.session.received.release(pp.PacketID); msg != nil {
+		c.server.publish(c.ID, msg)
+	} else {
+		comp.ReasonCode = packets.PacketIDNotFound
+	}
+	c.deliver(comp)
+}
+
+// Handle puback
+func (c *Client) puback(pp *packets.Puback) {
+	if c.session.inflight.ack(pp.PacketID, waitPuback) {
+		c.resume()
+	}
+}
+
+// Handle pubrec
+func (c *Client) pubrec(pp *packets.Pubrec) {
+	if pp.ReasonCode >= packets.UnspecifiedError {
+		if c.session.inflight.ack(pp.PacketID, waitPubrec) {
+			c.resume()
+		}
+		return
+	}
+	code := byte(packets.Success)
+	if !c.session.inflight.rec(pp.PacketID) {
+		code = packets.PacketIDNotFound
+	}
+	c.deliver(&packets.Pubrel{
+		Version:    c.Version,
+		ReasonCode: code,
+		PacketID:   pp.PacketID,
+	})
+}
+
+// Handle pubcomp
+func (c *Client) pubcomp(pp *packets.Pubcomp) {
+	if c.session.inflight.ack(pp.PacketID, waitPubcomp) {
+		c.resume()
+	}
+}
+
+// Resend unacknowledged messages of the session on reconnect
+func (c *Client) resend() {
+	for _, m := range c.session.inflight.all() {
+		if m.state == waitPubcomp {
+			c.deliver(&packets.Pubrel{
+				Version:  c.Version,
+				PacketID: m.publish.PacketID,
+			})
+			continue
+		}
+		if !remainExpiry(m.publish, m.expireAt) {
+			log.Debugf("publish: message expired cid=%s topic=%s", c.ID, m.publish.TopicName)
+			c.server.stats.DroppedExpired.Add(1)
+			c.session.inflight.remove(m.publish.PacketID)
+			continue
+		}
+		m.publish.Version = c.Version
+		m.publish.FixHeader.Dup = true
+		c.deliver(m.publish)
+	}
+	c.resume()
+}
+
+// Send pending messages once the inflight window is released
+func (c *Client) resume() {
+	for _, pp := range c.session.inflight.next() {
+		pp.Version = c.Version
+		c.deliver(pp)
+	}
 }
 
 // Handle Subscribe
@@ -328,7 +785,9 @@ func (c *Client) subscribeHandler(ps *packets.Subscribe) {
 	}
 
 	isExist := false
-	for i, subscription := range ps.Subscriptions {
+	retained := make([]*packets.Subscription, 0)
+	for i := range ps.Subscriptions {
+		subscription := &ps.Subscriptions[i]
 		if subscription.Qos > Cfg.Mqtt.MaximumQoS {
 			subscription.Qos = Cfg.Mqtt.MaximumQoS
 		}
@@ -338,10 +797,25 @@ func (c *Client) subscribeHandler(ps *packets.Subscribe) {
 			suback.Payload[i] = packets.Code(c.Version, packets.TopicFilterInvalid)
 			continue
 		}
+		filter := subscription.Topic
 		if len(topics) >= 2 && topics[0] == "$share" {
 			subscription.ShareName = topics[1]
+			filter = strings.Join(topics[2:], "/")
+		}
+		if !c.authorize(AclSubscribe, filter) {
+			log.Debugf("subscribe: not authorized cid=%s topic=%s", c.ID, subscription.Topic)
+			suback.Payload[i] = packets.NotAuthorized
+			if c.Version != packets.V5 {
+				suback.Payload[i] = packets.Failure
+			}
+			continue
 		}
 
+		if subscription.ShareName != "" && subscription.NoLocal {
+			log.Debugf("subscribe: no local on shared subscription cid=%s topic=%s", c.ID, subscription.Topic)
+			c.Kick(packets.ProtocolError, nil)
+			return
+		}
 		if !Cfg.Mqtt.SharedSub && subscription.ShareName != "" {
 			suback.Payload[i] = packets.Code(c.Version, packets.SharedSubNotSupported)
 			continue
@@ -358,7 +832,7 @@ func (c *Client) subscribeHandler(ps *packets.Subscribe) {
 		}
 
 		subscription.SubID = subid
-		isExist, err = c.server.topicStore.Subscribe(c.ID, &subscription)
+		isExist, err = c.server.topicStore.Subscribe(c.ID, subscription)
 		if err != nil {
 			log.Debugf("subscribe: failed cid=%s topic=%s %s", c.ID, subscription.Topic, err)
 			suback.Payload[i] = packets.UnspecifiedError
@@ -367,37 +841,111 @@ func (c *Client) subscribeHandler(ps *packets.Subscribe) {
 		suback.Payload[i] = subscription.Qos
 		log.Debugf("subscribe: succeed cid=%s topic=%s", c.ID, subscription.Topic)
 
-		if subscription.ShareName != "" || isExist || subscription.RetainHandling >= 2 {
+		if subscription.ShareName != "" {
 			continue
 		}
+		if !isExist {
+			c.server.cluster.Subscribe(c.ID, subscription.Topic)
+		}
 
-		c.server.cluster.Subscribe(c.ID, subscription.Topic)
-
-		// 保留信息
+		// retain handling 0: send at subscribe, 1: send at new subscribe, 2: not send
+		if Cfg.Mqtt.RetainAvailable && (subscription.RetainHandling == 0 || subscription.RetainHandling == 1 && !isExist) {
+			retained = append(retained, subscription)
+		}
+	}
+	c.deliver(suback)
+	for _, sub := range retained {
+		c.sendRetained(sub)
 	}
-	_ = c.writePacket(suback)
 }
 
 // Handle Unsubscribe
 func (c *Client) unsubscribeHandler(pu *packets.Unsubscribe) {
-	for _, topic := range pu.Topics {
-		c.server.topicStore.Unsubscribe(c.ID, topic)
-	}
 	ack := &packets.Unsuback{
 		Version:  c.Version,
 		PacketID: pu.PacketID,
-		Payload:  []byte{packets.Success},
+		Payload:  make([]byte, len(pu.Topics)),
 	}
-	_ = c.writePacket(ack)
+	for i, filter := range pu.Topics {
+		ack.Payload[i] = c.unsubscribe(filter)
+	}
+	c.deliver(ack)
 }
 
-// Handle auth
-func (c *Client) auth(pa *packets.Auth) {
+// Unsubscribe topic filter, returns the reason code
+func (c *Client) unsubscribe(filter string) byte {
+	name, share := filter, ""
+	if strings.HasPrefix(filter, "$share/") {
+		topics := strings.SplitN(filter, "/", 3)
+		if len(topics) < 3 || topics[1] == "" || strings.ContainsAny(topics[1], "+#") {
+			return packets.TopicFilterInvalid
+		}
+		share, name = topics[1], topics[2]
+	}
+	if !topic.IsValidFilter(name) {
+		return packets.TopicFilterInvalid
+	}
+	if !c.authorize(AclSubscribe, name) {
+		log.Debugf("unsubscribe: not authorized cid=%s topic=%s", c.ID, filter)
+		return packets.NotAuthorized
+	}
+	isExist, err := c.server.topicStore.Unsubscribe(c.ID, filter)
+	if err != nil {
+		log.Errorf("unsubscribe: %v cid=%s topic=%s", err, c.ID, filter)
+		return packets.UnspecifiedError
+	}
+	if !isExist {
+		return packets.NoSubscriptionExisted
+	}
+	log.Debugf("unsubscribe: succeed cid=%s topic=%s", c.ID, filter)
+	// shared subscriptions are not synced to cluster
+	if share != "" {
+		c.server.shared.leave(filter, c.ID, !c.server.topicStore.Subscribed(filter))
+	} else if !c.server.topicStore.Subscribed(filter) {
+		c.server.cluster.Unsubscribe(c.ID, filter)
+	}
+	return packets.Success
+}
 
+// Handle auth, client re-authenticates with the auth method of connect
+func (c *Client) auth(pa *packets.Auth) {
+	if c.authMethod == "" || pa.Properties.AuthMethod != c.authMethod {
+		c.Kick(packets.ProtocolError, nil)
+		return
+	}
+	var code byte
+	var data string
+	switch pa.ReasonCode {
+	case packets.ReAuthenticate:
+		code, data = c.startAuth(pa.Properties.AuthMethod, pa.Properties.AuthData)
+	case packets.ContinueAuthentication:
+		if c.authExchange == nil {
+			c.Kick(packets.ProtocolError, nil)
+			return
+		}
+		code, data = c.stepAuth(pa.Properties.AuthData)
+	default:
+		c.Kick(packets.ProtocolError, nil)
+		return
+	}
+	if code != packets.Success && code != packets.ContinueAuthentication {
+		c.Kick(code, nil)
+		return
+	}
+	if code == packets.Success {
+		c.aclCache = make(map[string]bool)
+	}
+	c.deliver(&packets.Auth{
+		ReasonCode: code,
+		Properties: &packets.Properties{
+			AuthMethod: c.authMethod,
+			AuthData:   data,
+		},
+	})
 }
 
 // Handle ping
 func (c *Client) pingReqHandler() {
 	resp := &packets.Pingresp{}
-	_ = c.writePacket(resp)
+	c.deliver(resp)
 }
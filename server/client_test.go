@@ -0,0 +1,265 @@
+package server
+
+import (
+	. "github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/pkg/packets"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeepAlive(t *testing.T) {
+	tests := []struct {
+		name string
+		ska  uint16
+		min  uint16
+		max  uint16
+		ka   uint16
+		want uint16
+	}{
+		{"unlimited", 0, 0, 0, 60, 60},
+		{"unlimited zero", 0, 0, 0, 0, 0},
+		{"server keep alive lowers", 30, 0, 0, 60, 30},
+		{"server keep alive sets zero", 30, 0, 0, 0, 30},
+		{"min raises", 0, 10, 0, 5, 10},
+		{"min sets zero", 0, 10, 0, 0, 10},
+		{"max lowers", 0, 0, 100, 200, 100},
+		{"max sets zero", 0, 0, 100, 0, 100},
+		{"within limits", 120, 10, 100, 50, 50},
+		{"max over server keep alive", 120, 10, 100, 0, 100},
+	}
+	mqtt := Cfg.Mqtt
+	defer func() { Cfg.Mqtt = mqtt }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Cfg.Mqtt.ServerKeepAlive, Cfg.Mqtt.MinKeepAlive, Cfg.Mqtt.MaxKeepAlive = tt.ska, tt.min, tt.max
+			if got := keepAlive(tt.ka); got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQos2Inbound(t *testing.T) {
+	s := testServer(t)
+	sub, _ := testConnect(t, s, "sub")
+	sub.subscribe(t, packets.Subscription{Qos: packets.Qos2}, "a/b")
+	pub, _ := testConnect(t, s, "pub", func(pc *packets.Connect) {
+		sei := uint32(60)
+		pc.Properties.SessionExpiryInterval = &sei
+	})
+
+	pubrec := func(tc *testConn, want byte) {
+		t.Helper()
+		rec, ok := tc.recv(t).(*packets.Pubrec)
+		if !ok || rec.ReasonCode != want {
+			t.Fatalf("got %+v, want pubrec %#x", rec, want)
+		}
+	}
+	pubcomp := func(tc *testConn, id uint16, want byte) {
+		t.Helper()
+		tc.send(t, &packets.Pubrel{FixHeader: &packets.FixHeader{PacketType: packets.PUBREL, Qos: packets.Qos1}, Version: packets.V5, PacketID: id})
+		comp, ok := tc.recv(t).(*packets.Pubcomp)
+		if !ok || comp.ReasonCode != want {
+			t.Fatalf("got %+v, want pubcomp %#x", comp, want)
+		}
+	}
+
+	// routed once on pubrel
+	pub.publish(t, "a/b", packets.Qos2, 1, "x")
+	pubrec(pub, packets.Success)
+	sub.none(t)
+	pub.send(t, &packets.Publish{
+		FixHeader: &packets.FixHeader{PacketType: packets.PUBLISH, Qos: packets.Qos2, Dup: true},
+		Version:   packets.V5,
+		PacketID:  1,
+		TopicName: "a/b",
+		Payload:   []byte("x"),
+	})
+	pubrec(pub, packets.Success)
+	pub.publish(t, "a/b", packets.Qos2, 1, "y")
+	pubrec(pub, packets.PacketIDInUse)
+	sub.none(t)
+	pubcomp(pub, 1, packets.Success)
+	if pp, ok := sub.recv(t).(*packets.Publish); !ok || string(pp.Payload) != "x" {
+		t.Fatalf("got %+v, want publish x", pp)
+	}
+	sub.none(t)
+	pubcomp(pub, 1, packets.PacketIDNotFound)
+
+	// released after reconnect of persistent session
+	pub.publish(t, "a/b", packets.Qos2, 2, "z")
+	pubrec(pub, packets.Success)
+	_ = pub.Close()
+	pub, ack := testConnect(t, s, "pub", func(pc *packets.Connect) {
+		sei := uint32(60)
+		pc.CleanStart = false
+		pc.Properties.SessionExpiryInterval = &sei
+	})
+	if !ack.SessionPresent {
+		t.Fatal("got no session present, want present")
+	}
+	sub.none(t)
+	pubcomp(pub, 2, packets.Success)
+	if pp, ok := sub.recv(t).(*packets.Publish); !ok || string(pp.Payload) != "z" {
+		t.Fatalf("got %+v, want publish z", pp)
+	}
+}
+
+func TestPublishProperties(t *testing.T) {
+	s := testServer(t)
+	sub, _ := testConnect(t, s, "sub")
+	sub.subscribe(t, packets.Subscription{}, "req/+")
+	pub, _ := testConnect(t, s, "pub")
+	format, expiry := byte(1), uint32(60)
+	props := &packets.Properties{
+		PayloadFormat:   &format,
+		MessageExpiry:   &expiry,
+		ContentType:     "application/json",
+		ResponseTopic:   "resp/pub",
+		CorrelationData: "\x00\x01id",
+		User:            []packets.UserProperty{{Key: "k", Value: "1"}, {Key: "k", Value: "2"}},
+	}
+	pub.send(t, &packets.Publish{
+		FixHeader:  &packets.FixHeader{PacketType: packets.PUBLISH},
+		Version:    packets.V5,
+		TopicName:  "req/a",
+		Properties: props,
+		Payload:    []byte(`{}`),
+	})
+	pp, ok := sub.recv(t).(*packets.Publish)
+	if !ok {
+		t.Fatal("want publish")
+	}
+	got := pp.Properties
+	if got == nil || *got.PayloadFormat != format || got.MessageExpiry == nil || *got.MessageExpiry > expiry ||
+		got.ContentType != props.ContentType || got.ResponseTopic != props.ResponseTopic ||
+		got.CorrelationData != props.CorrelationData || !reflect.DeepEqual(got.User, props.User) {
+		t.Fatalf("got %+v, want %+v", got, props)
+	}
+}
+
+func TestPayloadFormatInvalid(t *testing.T) {
+	format := byte(1)
+	tests := []struct {
+		name string
+		qos  byte
+		want packets.Packet
+	}{
+		{"qos 0", packets.Qos0, &packets.Disconnect{}},
+		{"qos 1", packets.Qos1, &packets.Puback{}},
+		{"qos 2", packets.Qos2, &packets.Pubrec{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			sub, _ := testConnect(t, s, "sub")
+			sub.subscribe(t, packets.Subscription{Qos: packets.Qos2}, "a/b")
+			pub, _ := testConnect(t, s, "pub")
+			pub.send(t, &packets.Publish{
+				FixHeader:  &packets.FixHeader{PacketType: packets.PUBLISH, Qos: tt.qos},
+				Version:    packets.V5,
+				PacketID:   1,
+				TopicName:  "a/b",
+				Properties: &packets.Properties{PayloadFormat: &format},
+				Payload:    []byte{0xff, 0xfe},
+			})
+			got := pub.recv(t)
+			var code byte
+			switch p := got.(type) {
+			case *packets.Disconnect:
+				code = p.ReasonCode
+			case *packets.Puback:
+				code = p.ReasonCode
+			case *packets.Pubrec:
+				code = p.ReasonCode
+			}
+			if reflect.TypeOf(got) != reflect.TypeOf(tt.want) || code != packets.PayloadFormatInvalid {
+				t.Fatalf("got %T %+v, want %T of %#x", got, got, tt.want, packets.PayloadFormatInvalid)
+			}
+			sub.none(t)
+		})
+	}
+}
+
+func TestResponseInfo(t *testing.T) {
+	info := Cfg.Mqtt.ResponseInfo
+	defer func() { Cfg.Mqtt.ResponseInfo = info }()
+	tests := []struct {
+		name    string
+		info    string
+		request byte
+		want    string
+	}{
+		{"requested", "reply/%u/%c/", 1, "reply/u/c/"},
+		{"not requested", "reply/%u/%c/", 0, ""},
+		{"not configured", "", 1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Cfg.Mqtt.ResponseInfo = tt.info
+			s := testServer(t)
+			_, ack := testConnect(t, s, "c", func(pc *packets.Connect) {
+				pc.Username = "u"
+				pc.Properties.RequestResponseInfo = &tt.request
+			})
+			if got := ack.Properties.ResponseInfo; got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssignClientID(t *testing.T) {
+	tests := []struct {
+		name       string
+		version    byte
+		cleanStart bool
+		want       byte
+		assigned   bool
+	}{
+		{"v5", packets.V5, true, packets.Success, true},
+		{"v5 persistent", packets.V5, false, packets.Success, true},
+		{"v311", packets.V311, true, packets.Accepted, true},
+		{"v311 persistent", packets.V311, false, packets.RefusedIDRejected, false},
+		{"v31", packets.V31, true, packets.RefusedIDRejected, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			_, ack := testConnect(t, s, "", func(pc *packets.Connect) {
+				pc.Version, pc.CleanStart = tt.version, tt.cleanStart
+				if tt.version == packets.V31 {
+					pc.Protocol = "MQIsdp"
+				}
+			})
+			if ack.ReasonCode != tt.want {
+				t.Fatalf("got %#x, want %#x", ack.ReasonCode, tt.want)
+			}
+			var cid string
+			s.clients.Range(func(k, _ any) bool {
+				cid = k.(string)
+				return false
+			})
+			if (cid != "") != tt.assigned {
+				t.Fatalf("got client id %q, want assigned %v", cid, tt.assigned)
+			}
+			if tt.assigned && !strings.HasPrefix(cid, Cfg.Mqtt.ClientIDPrefix) {
+				t.Fatalf("got client id %q, want prefix %q", cid, Cfg.Mqtt.ClientIDPrefix)
+			}
+			if tt.version == packets.V5 && ack.Properties.AssignedClientID != cid {
+				t.Fatalf("got assigned client id %q, want %q", ack.Properties.AssignedClientID, cid)
+			}
+		})
+	}
+}
+
+func TestAssignClientIDUnique(t *testing.T) {
+	name := Cfg.NodeName
+	defer func() { Cfg.NodeName = name }()
+	Cfg.NodeName = "n1"
+	a, b := assignClientID(), assignClientID()
+	if a == b || !strings.HasPrefix(a, Cfg.Mqtt.ClientIDPrefix+"n1-") {
+		t.Fatalf("got %q and %q, want unique ids of node n1", a, b)
+	}
+}
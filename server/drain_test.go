@@ -0,0 +1,168 @@
+package server
+
+import (
+	. "github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/pkg/packets"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	tests := []struct {
+		name      string
+		rate      int
+		reference string
+		code      byte
+		min       time.Duration
+	}{
+		{"shutting down", 0, "", packets.ServerShuttingDown, 0},
+		{"use another server", 0, "other:1883", packets.UseAnotherServer, 0},
+		{"rate", 10, "", packets.ServerShuttingDown, 300 * time.Millisecond},
+	}
+	drain := Cfg.Drain
+	defer func() { Cfg.Drain = drain }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Cfg.Drain.Rate, Cfg.Drain.ServerReference, Cfg.Drain.Timeout = tt.rate, tt.reference, 5*time.Second
+			s := testServer(t)
+			tcs := make([]*testConn, 0)
+			for _, cid := range []string{"a", "b", "c"} {
+				tc, _ := testConnect(t, s, cid, withExpiry(60))
+				tcs = append(tcs, tc)
+			}
+			start := time.Now()
+			s.drain()
+			if d := time.Since(start); d < tt.min {
+				t.Fatalf("drained in %v, want at least %v", d, tt.min)
+			}
+			for i, tc := range tcs {
+				pd, ok := tc.recv(t).(*packets.Disconnect)
+				if !ok || pd.ReasonCode != tt.code {
+					t.Fatalf("client %d: got %+v, want disconnect %#x", i, pd, tt.code)
+				}
+				if tt.reference != "" && (pd.Properties == nil || pd.Properties.ServerReference != tt.reference) {
+					t.Fatalf("client %d: got %+v, want server reference %s", i, pd.Properties, tt.reference)
+				}
+				tc.wait(t)
+			}
+			// persistent sessions are saved before the stores are closed
+			ss, err := s.sessionStore.All()
+			if err != nil || len(ss) != 3 {
+				t.Fatalf("got %d sessions %v, want 3", len(ss), err)
+			}
+			for _, se := range ss {
+				if se.DisconnectedAt == 0 {
+					t.Fatalf("session %s: got no disconnected at", se.ClientID)
+				}
+			}
+		})
+	}
+}
+
+// Free tcp address of loopback
+func testAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// Write packet to connection, returns the packet type of reply
+func testRoundTrip(conn net.Conn, p packets.Packet) (byte, error) {
+	if err := p.Pack(conn); err != nil {
+		return 0, err
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	var fh packets.FixHeader
+	if err := fh.Unpack(conn); err != nil {
+		return 0, err
+	}
+	// only the server side of packets is decoded, the body of reply is skipped
+	_, err := io.CopyN(io.Discard, conn, int64(fh.RemainLen))
+	return fh.PacketType, err
+}
+
+func TestUnlisten(t *testing.T) {
+	lc := Cfg.Listeners["tcp"]
+	saved := *lc
+	defer func() { *lc = saved }()
+	addr := testAddr(t)
+	host, port, _ := net.SplitHostPort(addr)
+	lc.Enable, lc.Host, lc.Addr = true, host, addr
+	lc.Port, _ = strconv.Atoi(port)
+
+	s := testServer(t)
+	s.listen("tcp")
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	typ, err := testRoundTrip(conn, &packets.Connect{
+		FixHeader:  &packets.FixHeader{PacketType: packets.CONNECT},
+		Protocol:   "MQTT",
+		Version:    packets.V5,
+		CleanStart: true,
+		ClientID:   "c",
+		Properties: &packets.Properties{},
+	})
+	if err != nil || typ != packets.CONNACK {
+		t.Fatalf("got %d %v, want connack", typ, err)
+	}
+
+	// the connection accepted keeps running after its listener is closed
+	s.unlisten("tcp")
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Fatal("dial: got nil, want error of closed listener")
+	}
+	if typ, err := testRoundTrip(conn, &packets.Pingreq{FixHeader: &packets.FixHeader{PacketType: packets.PINGREQ}}); err != nil || typ != packets.PINGRESP {
+		t.Fatalf("got %d %v, want pingresp", typ, err)
+	}
+}
+
+func TestListenerKey(t *testing.T) {
+	lc := Cfg.Listeners["tls"]
+	saved := *lc
+	defer func() { *lc = saved }()
+	lc.Enable = false
+	if key := listenerKey("tls"); key != "" {
+		t.Fatalf("got %q, want empty key of disabled listener", key)
+	}
+
+	lc.Enable = true
+	lc.TLSCert = t.TempDir() + "/cert.pem"
+	if err := os.WriteFile(lc.TLSCert, []byte("cert"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	key := listenerKey("tls")
+	if got := listenerKey("tls"); got != key {
+		t.Fatalf("got %q, want unchanged %q", got, key)
+	}
+	// certificate file renewed in place
+	modified := time.Now().Add(time.Minute)
+	if err := os.Chtimes(lc.TLSCert, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	if got := listenerKey("tls"); got == key {
+		t.Fatal("got unchanged key, want changed by certificate file")
+	}
+	key = listenerKey("tls")
+	lc.Port++
+	if got := listenerKey("tls"); got == key {
+		t.Fatal("got unchanged key, want changed by port")
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"github.com/laomar/gomq/pkg/packets"
+	"time"
+)
+
+// Absolute expiry time of message by its message expiry interval, unix milliseconds, 0 means never
+func expireAt(pp *packets.Publish) int64 {
+	if pp.Properties == nil || pp.Properties.MessageExpiry == nil {
+		return 0
+	}
+	return time.Now().Add(time.Duration(*pp.Properties.MessageExpiry) * time.Second).UnixMilli()
+}
+
+// Whether message of the expiry time has expired
+func expired(at int64) bool {
+	return at > 0 && time.Now().UnixMilli() >= at
+}
+
+// Rewrite message expiry interval to the remaining seconds, returns false when message has expired
+func remainExpiry(pp *packets.Publish, at int64) bool {
+	if at == 0 {
+		return true
+	}
+	remain := at - time.Now().UnixMilli()
+	if remain <= 0 {
+		return false
+	}
+	// round up so that a message is never reported as expired before it is
+	secs := uint32((remain + 999) / 1000)
+	props := packets.Properties{}
+	if pp.Properties != nil {
+		props = *pp.Properties
+	}
+	props.MessageExpiry = &secs
+	pp.Properties = &props
+	return true
+}
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"github.com/laomar/gomq/log"
+	"github.com/laomar/gomq/pkg/packets"
+	infl "github.com/laomar/gomq/store/inflight"
+	"github.com/laomar/gomq/store/queue"
+	"sort"
+	"sync"
+)
+
+// Packet ids of a session, 0 is not used
+const maxPacketIDs = 65535
+
+// Outbound message state
+const (
+	waitPuback = iota
+	waitPubrec
+	waitPubcomp
+)
+
+type inflightMsg struct {
+	seq      uint64
+	publish  *packets.Publish
+	state    byte
+	share    string
+	expireAt int64
+}
+
+// Outbound QoS 1/2 messages waiting for acknowledgement, messages beyond the window are queued
+type inflight struct {
+	sync.Mutex
+	cid      string
+	max      uint16
+	nextID   uint16
+	seq      uint64
+	msgs     map[uint16]*inflightMsg
+	queue    queue.Store
+	msgStore infl.Store
+	persist  bool
+}
+
+func newInflight(cid string, q queue.Store, ms infl.Store) *inflight {
+	return &inflight{
+		cid:      cid,
+		msgs:     make(map[uint16]*inflightMsg),
+		queue:    q,
+		msgStore: ms,
+	}
+}
+
+// Restore messages of persistent session
+func (i *inflight) load(ms []*infl.Message) {
+	defer i.Unlock()
+	i.Lock()
+	i.persist = true
+	for _, m := range ms {
+		i.msgs[m.PacketID] = &inflightMsg{
+			seq:      m.Seq,
+			publish:  m.Publish,
+			state:    m.State,
+			share:    m.Share,
+			expireAt: m.ExpireAt,
+		}
+		if m.Seq > i.seq {
+			i.seq = m.Seq
+		}
+	}
+}
+
+// Save message state, only messages of persistent sessions are saved
+func (i *inflight) save(m *inflightMsg) {
+	if !i.persist {
+		return
+	}
+	err := i.msgStore.Set(i.cid, &infl.Message{
+		PacketID: m.publish.PacketID,
+		State:    m.state,
+		Seq:      m.seq,
+		Share:    m.share,
+		Publish:  m.publish,
+		ExpireAt: m.expireAt,
+	})
+	if err != nil {
+		log.Errorf("inflight: %v cid=%s", err, i.cid)
+	}
+}
+
+func (i *inflight) del(id uint16) {
+	if !i.persist {
+		return
+	}
+	if err := i.msgStore.Del(i.cid, false, id); err != nil {
+		log.Errorf("inflight: %v cid=%s", err, i.cid)
+	}
+}
+
+// Allocate a free packet id, returns false when all packet ids are in use
+func (i *inflight) packetID() (uint16, bool) {
+	if len(i.msgs) >= maxPacketIDs {
+		return 0, false
+	}
+	for {
+		i.nextID++
+		if i.nextID == 0 {
+			continue
+		}
+		if _, ok := i.msgs[i.nextID]; !ok {
+			return i.nextID, true
+		}
+	}
+}
+
+func (i *inflight) full() bool {
+	return i.max > 0 && len(i.msgs) >= int(i.max)
+}
+
+// Add message to the window, returns false when there is no free packet id
+func (i *inflight) add(pp *packets.Publish, share string, at int64) bool {
+	id, ok := i.packetID()
+	if !ok {
+		return false
+	}
+	pp.PacketID = id
+	state := byte(waitPuback)
+	if pp.FixHeader.Qos == packets.Qos2 {
+		state = waitPubrec
+	}
+	i.seq++
+	m := &inflightMsg{
+		seq:      i.seq,
+		publish:  pp,
+		state:    state,
+		share:    share,
+		expireAt: at,
+	}
+	i.msgs[pp.PacketID] = m
+	i.save(m)
+	return true
+}
+
+func (i *inflight) resize(max uint16) {
+	defer i.Unlock()
+	i.Lock()
+	i.max = max
+}
+
+// Messages are saved when the session outlives the connection
+func (i *inflight) setPersist(persist bool) {
+	defer i.Unlock()
+	i.Lock()
+	i.persist = persist
+}
+
+// Number of messages waiting for acknowledgement and queued
+func (i *inflight) len() int {
+	defer i.Unlock()
+	i.Lock()
+	return len(i.msgs) + i.queue.Len(i.cid)
+}
+
+// Unacknowledged messages in sending order
+func (i *inflight) all() []*inflightMsg {
+	defer i.Unlock()
+	i.Lock()
+	ms := make([]*inflightMsg, 0, len(i.msgs))
+	for _, m := range i.msgs {
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(a, b int) bool {
+		return ms[a].seq < ms[b].seq
+	})
+	return ms
+}
+
+// Push message into inflight window, returns false when the window is full and the message is queued,
+// at is the expiry time of message
+func (i *inflight) push(pp *packets.Publish, share string, at int64) (bool, error) {
+	defer i.Unlock()
+	i.Lock()
+	if i.queue.Len(i.cid) > 0 || i.full() || !i.add(pp, share, at) {
+		return false, i.queue.Push(i.cid, &queue.Message{Publish: pp, ExpireAt: at})
+	}
+	return true, nil
+}
+
+// Move queued messages into inflight window, qos 0 messages take no place in the window
+func (i *inflight) next() []*packets.Publish {
+	defer i.Unlock()
+	i.Lock()
+	pps := make([]*packets.Publish, 0)
+	for !i.full() && len(i.msgs) < maxPacketIDs {
+		// popped messages never run out of packet ids
+		n := maxPacketIDs - len(i.msgs)
+		if i.max > 0 {
+			n = int(i.max) - len(i.msgs)
+		}
+		if n > 100 {
+			n = 100
+		}
+		qms, err := i.queue.Pop(i.cid, n)
+		if err != nil {
+			log.Errorf("queue: %v cid=%s", err, i.cid)
+		}
+		if len(qms) == 0 {
+			break
+		}
+		for _, m := range qms {
+			pp := m.Publish
+			if !remainExpiry(pp, m.ExpireAt) {
+				log.Debugf("queue: message expired cid=%s topic=%s", i.cid, pp.TopicName)
+				continue
+			}
+			if pp.FixHeader.Qos > packets.Qos0 {
+				i.add(pp, "", m.ExpireAt)
+			}
+			pps = append(pps, pp)
+		}
+	}
+	return pps
+}
+
+// Remove message without acknowledgement
+func (i *inflight) remove(id uint16) bool {
+	defer i.Unlock()
+	i.Lock()
+	if _, ok := i.msgs[id]; ok {
+		delete(i.msgs, id)
+		i.del(id)
+		return true
+	}
+	return false
+}
+
+// Take shared subscription messages not yet received by client, take reports whether a message is taken
+func (i *inflight) takeShared(take func(*inflightMsg) bool) []*inflightMsg {
+	defer i.Unlock()
+	i.Lock()
+	ms := make([]*inflightMsg, 0)
+	for id, m := range i.msgs {
+		if m.share != "" && m.state != waitPubcomp && take(m) {
+			ms = append(ms, m)
+			delete(i.msgs, id)
+			i.del(id)
+		}
+	}
+	sort.Slice(ms, func(a, b int) bool {
+		return ms[a].seq < ms[b].seq
+	})
+	return ms
+}
+
+// Acknowledge puback or pubcomp
+func (i *inflight) ack(id uint16, state byte) bool {
+	defer i.Unlock()
+	i.Lock()
+	if m, ok := i.msgs[id]; ok && m.state == state {
+		delete(i.msgs, id)
+		i.del(id)
+		return true
+	}
+	return false
+}
+
+// Acknowledge pubrec
+func (i *inflight) rec(id uint16) bool {
+	defer i.Unlock()
+	i.Lock()
+	if m, ok := i.msgs[id]; ok && m.state == waitPubrec {
+		m.state = waitPubcomp
+		i.save(m)
+		return true
+	}
+	return false
+}
+
+// Inbound QoS 2 messages waiting for pubrel
+type received struct {
+	sync.Mutex
+	cid      string
+	msgs     map[uint16]*packets.Publish
+	msgStore infl.Store
+	persist  bool
+}
+
+func newReceived(cid string, ms infl.Store) *received {
+	return &received{
+		cid:      cid,
+		msgs:     make(map[uint16]*packets.Publish),
+		msgStore: ms,
+	}
+}
+
+// Restore messages of persistent session
+func (r *received) load(ms []*infl.Message) {
+	defer r.Unlock()
+	r.Lock()
+	r.persist = true
+	for _, m := range ms {
+		r.msgs[m.PacketID] = m.Publish
+	}
+}
+
+// Messages are saved when the session outlives the connection
+func (r *received) setPersist(persist bool) {
+	defer r.Unlock()
+	r.Lock()
+	r.persist = persist
+}
+
+// Store message, returns false when the packet id is already in use
+func (r *received) store(pp *packets.Publish) bool {
+	defer r.Unlock()
+	r.Lock()
+	if _, ok := r.msgs[pp.PacketID]; ok {
+		return false
+	}
+	r.msgs[pp.PacketID] = pp
+	if r.persist {
+		err := r.msgStore.Set(r.cid, &infl.Message{PacketID: pp.PacketID, Inbound: true, Publish: pp})
+		if err != nil {
+			log.Errorf("inflight: %v cid=%s", err, r.cid)
+		}
+	}
+	return true
+}
+
+// Number of messages waiting for pubrel
+func (r *received) len() int {
+	defer r.Unlock()
+	r.Lock()
+	return len(r.msgs)
+}
+
+// Release message by packet id
+func (r *received) release(id uint16) *packets.Publish {
+	defer r.Unlock()
+	r.Lock()
+	pp, ok := r.msgs[id]
+	if ok {
+		delete(r.msgs, id)
+		if r.persist {
+			if err := r.msgStore.Del(r.cid, true, id); err != nil {
+				log.Errorf("inflight: %v cid=%s", err, r.cid)
+			}
+		}
+	}
+	return pp
+}
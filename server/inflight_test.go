@@ -0,0 +1,296 @@
+package server
+
+import (
+	"github.com/laomar/gomq/pkg/packets"
+	infl "github.com/laomar/gomq/store/inflight"
+	"github.com/laomar/gomq/store/queue"
+	"testing"
+	"time"
+)
+
+func testPublish(qos byte) *packets.Publish {
+	return &packets.Publish{
+		FixHeader: &packets.FixHeader{PacketType: packets.PUBLISH, Qos: qos},
+		TopicName: "a/b",
+		Payload:   []byte("x"),
+	}
+}
+
+func TestInflightWindow(t *testing.T) {
+	tests := []struct {
+		name   string
+		max    uint16
+		qos    []byte
+		sent   int
+		queued int
+	}{
+		{"unlimited", 0, []byte{1, 2, 1, 2}, 4, 0},
+		{"within window", 4, []byte{1, 2}, 2, 0},
+		{"full window", 2, []byte{1, 2, 1, 2}, 2, 2},
+		{"window of one", 1, []byte{2, 1, 1}, 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newInflight("c", queue.NewRam(), infl.NewRam())
+			i.resize(tt.max)
+			sent := 0
+			for _, qos := range tt.qos {
+				ok, err := i.push(testPublish(qos), "", 0)
+				if err != nil {
+					t.Fatalf("push: %v", err)
+				}
+				if ok {
+					sent++
+				}
+			}
+			if sent != tt.sent || i.queue.Len("c") != tt.queued {
+				t.Fatalf("sent %d queued %d, want %d %d", sent, i.queue.Len("c"), tt.sent, tt.queued)
+			}
+			if n := i.len(); n != tt.sent+tt.queued {
+				t.Fatalf("len %d, want %d", n, tt.sent+tt.queued)
+			}
+		})
+	}
+}
+
+func TestInflightAck(t *testing.T) {
+	type op struct {
+		kind string
+		ok   bool
+	}
+	tests := []struct {
+		name string
+		qos  byte
+		ops  []op
+	}{
+		{"qos 1 puback", 1, []op{{"puback", true}, {"puback", false}}},
+		{"qos 1 pubrec", 1, []op{{"pubrec", false}, {"pubcomp", false}, {"puback", true}}},
+		{"qos 2 flow", 2, []op{{"puback", false}, {"pubcomp", false}, {"pubrec", true}, {"pubrec", false}, {"pubcomp", true}}},
+		{"qos 2 pubrec error", 2, []op{{"pubrec error", true}, {"pubcomp", false}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newInflight("c", queue.NewRam(), infl.NewRam())
+			pp := testPublish(tt.qos)
+			if _, err := i.push(pp, "", 0); err != nil {
+				t.Fatalf("push: %v", err)
+			}
+			for _, o := range tt.ops {
+				var ok bool
+				switch o.kind {
+				case "puback":
+					ok = i.ack(pp.PacketID, waitPuback)
+				case "pubrec":
+					ok = i.rec(pp.PacketID)
+				case "pubrec error":
+					ok = i.ack(pp.PacketID, waitPubrec)
+				case "pubcomp":
+					ok = i.ack(pp.PacketID, waitPubcomp)
+				}
+				if ok != o.ok {
+					t.Fatalf("%s: got %v, want %v", o.kind, ok, o.ok)
+				}
+			}
+			if n := i.len(); n != 0 {
+				t.Fatalf("len %d after flow, want 0", n)
+			}
+		})
+	}
+}
+
+func TestInflightRequeue(t *testing.T) {
+	i := newInflight("c", queue.NewRam(), infl.NewRam())
+	i.resize(2)
+	pps := []*packets.Publish{testPublish(1), testPublish(2), testPublish(1), testPublish(2)}
+	for _, pp := range pps {
+		if _, err := i.push(pp, "", 0); err != nil {
+			t.Fatalf("push: %v", err)
+		}
+	}
+	if pp := i.next(); len(pp) != 0 {
+		t.Fatalf("next of full window got %d messages", len(pp))
+	}
+
+	// queued messages take the place of acknowledged ones in order
+	i.ack(pps[0].PacketID, waitPuback)
+	next := i.next()
+	if len(next) != 1 || next[0] != pps[2] {
+		t.Fatalf("next got %v, want third message", next)
+	}
+	if next[0].PacketID == pps[1].PacketID {
+		t.Fatalf("packet id %d is in use", next[0].PacketID)
+	}
+	i.rec(pps[1].PacketID)
+	i.ack(pps[1].PacketID, waitPubcomp)
+	i.ack(pps[2].PacketID, waitPuback)
+	if next = i.next(); len(next) != 1 || next[0] != pps[3] {
+		t.Fatalf("next got %v, want fourth message", next)
+	}
+
+	// unacknowledged messages are resent in sending order
+	ms := i.all()
+	if len(ms) != 1 || ms[0].publish != pps[3] || ms[0].state != waitPubrec {
+		t.Fatalf("all got %v, want fourth message waiting for pubrec", ms)
+	}
+}
+
+func TestInflightPacketID(t *testing.T) {
+	i := newInflight("c", queue.NewRam(), infl.NewRam())
+	i.msgs[1] = &inflightMsg{}
+	i.nextID = 65535
+	if id, ok := i.packetID(); id != 2 || !ok {
+		t.Fatalf("got packet id %d %v, want 2", id, ok)
+	}
+
+	// unlimited window queues messages once all packet ids are in use
+	for id := 2; id <= 65535; id++ {
+		i.msgs[uint16(id)] = &inflightMsg{}
+	}
+	if id, ok := i.packetID(); ok {
+		t.Fatalf("got packet id %d, want none", id)
+	}
+	if ok, err := i.push(testPublish(1), "", 0); ok || err != nil || i.queue.Len("c") != 1 {
+		t.Fatalf("push got %v %v, want the message queued", ok, err)
+	}
+	if next := i.next(); len(next) != 0 {
+		t.Fatalf("next got %d messages, want none", len(next))
+	}
+	delete(i.msgs, 7)
+	if next := i.next(); len(next) != 1 || next[0].PacketID != 7 {
+		t.Fatalf("next got %v, want the queued message of packet id 7", next)
+	}
+}
+
+func TestInflightShared(t *testing.T) {
+	i := newInflight("c", queue.NewRam(), infl.NewRam())
+	plain, shared, released := testPublish(1), testPublish(2), testPublish(2)
+	i.push(plain, "", 0)
+	i.push(shared, "$share/g/a/b", 0)
+	i.push(released, "$share/g/a/b", 0)
+	i.rec(released.PacketID)
+
+	// messages received by client stay with it
+	ms := i.takeShared(func(*inflightMsg) bool { return true })
+	if len(ms) != 1 || ms[0].publish != shared {
+		t.Fatalf("takeShared got %v, want the shared message waiting for pubrec", ms)
+	}
+	if n := i.len(); n != 2 {
+		t.Fatalf("len %d, want 2", n)
+	}
+}
+
+func TestInflightPersist(t *testing.T) {
+	ms := infl.NewRam()
+	i := newInflight("c", queue.NewRam(), ms)
+	i.push(testPublish(1), "", 0)
+	if saved, _ := ms.All("c"); len(saved) != 0 {
+		t.Fatalf("message of clean session is saved")
+	}
+
+	i.setPersist(true)
+	q1, q2 := testPublish(1), testPublish(2)
+	i.push(q1, "", 0)
+	i.push(q2, "", 0)
+	i.rec(q2.PacketID)
+	saved, _ := ms.All("c")
+	if len(saved) != 2 {
+		t.Fatalf("saved %d messages, want 2", len(saved))
+	}
+
+	// restored session continues the flow of saved messages
+	r := newInflight("c", queue.NewRam(), ms)
+	r.load(saved)
+	all := r.all()
+	if len(all) != 2 || all[0].publish.PacketID != q1.PacketID || all[1].state != waitPubcomp {
+		t.Fatalf("restored %v, want qos 1 then qos 2 waiting for pubcomp", all)
+	}
+	if !r.ack(q2.PacketID, waitPubcomp) || !r.ack(q1.PacketID, waitPuback) {
+		t.Fatalf("restored messages are not acknowledged")
+	}
+	if saved, _ = ms.All("c"); len(saved) != 0 {
+		t.Fatalf("acknowledged messages are still saved")
+	}
+	r.push(testPublish(1), "", 0)
+	if seq := r.all()[0].seq; seq <= all[1].seq {
+		t.Fatalf("sequence %d restarts below restored %d", seq, all[1].seq)
+	}
+}
+
+func TestReceived(t *testing.T) {
+	tests := []struct {
+		name    string
+		persist bool
+		saved   int
+	}{
+		{"clean session", false, 0},
+		{"persistent session", true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms := infl.NewRam()
+			r := newReceived("c", ms)
+			r.setPersist(tt.persist)
+			p1, p2 := testPublish(2), testPublish(2)
+			p1.PacketID, p2.PacketID = 1, 2
+			if !r.store(p1) || !r.store(p2) {
+				t.Fatalf("store of new packet id failed")
+			}
+			if r.store(p1) {
+				t.Fatalf("store of packet id in use succeeded")
+			}
+			if saved, _ := ms.All("c"); len(saved) != tt.saved {
+				t.Fatalf("saved %d messages, want %d", len(saved), tt.saved)
+			}
+			if pp := r.release(1); pp != p1 {
+				t.Fatalf("release got %v, want first message", pp)
+			}
+			if pp := r.release(1); pp != nil {
+				t.Fatalf("second release got %v, want nil", pp)
+			}
+			if n := r.len(); n != 1 {
+				t.Fatalf("len %d, want 1", n)
+			}
+			if saved, _ := ms.All("c"); tt.persist && (len(saved) != 1 || !saved[0].Inbound) {
+				t.Fatalf("saved %v after release, want the second inbound message", saved)
+			}
+
+			// restored session releases saved message
+			if tt.persist {
+				saved, _ := ms.All("c")
+				l := newReceived("c", ms)
+				l.load(saved)
+				if pp := l.release(2); pp != p2 {
+					t.Fatalf("restored release got %v, want second message", pp)
+				}
+			}
+		})
+	}
+}
+
+func TestInflightExpiry(t *testing.T) {
+	ms := infl.NewRam()
+	i := newInflight("c", queue.NewRam(), ms)
+	i.setPersist(true)
+	i.resize(1)
+	now := time.Now().UnixMilli()
+	sent, live, dead := testPublish(1), testPublish(1), testPublish(1)
+	i.push(sent, "", now+60000)
+	i.push(dead, "", now-1)
+	i.push(live, "", now+30000)
+	if saved, _ := ms.All("c"); len(saved) != 1 || saved[0].ExpireAt != now+60000 {
+		t.Fatalf("saved %v, want the expiry time of sent message", saved)
+	}
+
+	// expired queued message is dropped, the remaining interval is forwarded
+	i.ack(sent.PacketID, waitPuback)
+	next := i.next()
+	if len(next) != 1 || next[0] != live {
+		t.Fatalf("next got %v, want the live message", next)
+	}
+	if e := live.Properties.MessageExpiry; e == nil || *e != 30 {
+		t.Fatalf("message expiry %v, want 30", e)
+	}
+	if all := i.all(); all[0].expireAt != now+30000 {
+		t.Fatalf("expiry time %d, want %d", all[0].expireAt, now+30000)
+	}
+}
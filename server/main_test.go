@@ -0,0 +1,19 @@
+package server
+
+import (
+	. "github.com/laomar/gomq/config"
+	"os"
+	"testing"
+)
+
+// Config of tests is read from ./config/gomq.toml, stores of tests write into a temporary data dir
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "gomq-test")
+	if err != nil {
+		panic(err)
+	}
+	Cfg.DataDir = dir
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,1012 @@
+package server
+
+import (
+	"errors"
+	. "github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/log"
+	"github.com/laomar/gomq/pkg/mqttsn"
+	"github.com/laomar/gomq/pkg/packets"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Connection exchanging packets instead of bytes, mqtt-sn clients are bridged by it
+type packetConn interface {
+	ReadPacket() (packets.Packet, error)
+	WritePacket(p packets.Packet) error
+}
+
+var errPacketConn = errors.New("mqttsn: packet connection")
+
+// MQTT-SN gateway, every udp client is bridged into a v5 client of server
+type gateway struct {
+	server        *Server
+	conn          *net.UDPConn
+	id            byte
+	qosM1         bool
+	qosM1ID       string
+	qosM1Username string
+	retryInterval time.Duration
+	retryCount    int
+	maxBuffered   int
+	topics        map[uint16]string
+	ids           map[string]uint16
+	conns         sync.Map
+}
+
+func newGateway(s *Server, conn *net.UDPConn) *gateway {
+	lc := Cfg.Listeners["mqttsn"]
+	g := &gateway{
+		server:        s,
+		conn:          conn,
+		id:            lc.GatewayID,
+		qosM1:         lc.QosM1,
+		qosM1ID:       lc.QosM1ClientID,
+		qosM1Username: lc.QosM1Username,
+		retryInterval: lc.RetryInterval,
+		retryCount:    lc.RetryCount,
+		maxBuffered:   lc.MaxBuffered,
+		topics:        make(map[uint16]string),
+		ids:           make(map[string]uint16),
+	}
+	for tid, name := range lc.Predefined {
+		g.topics[tid] = name
+		g.ids[name] = tid
+	}
+	return g
+}
+
+func (g *gateway) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := g.conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Debugf("mqttsn: %v", err)
+			continue
+		}
+		p, err := mqttsn.ReadPacket(buf[:n])
+		if err != nil {
+			log.Debugf("mqttsn: %v addr=%s", err, addr)
+			continue
+		}
+		g.handle(addr, p)
+	}
+}
+
+// Disconnect all clients, udp clients can not outlive the listener
+func (g *gateway) close() {
+	g.conns.Range(func(_, v any) bool {
+		v.(*snConn).Close()
+		return true
+	})
+}
+
+func (g *gateway) send(addr *net.UDPAddr, p mqttsn.Packet) {
+	if err := p.Pack(&udpWriter{conn: g.conn, addr: addr}); err != nil {
+		log.Debugf("mqttsn: %v addr=%s", err, addr)
+	}
+}
+
+type udpWriter struct {
+	conn *net.UDPConn
+	addr *net.UDPAddr
+}
+
+func (w *udpWriter) Write(b []byte) (int, error) {
+	return w.conn.WriteToUDP(b, w.addr)
+}
+
+func (g *gateway) handle(addr *net.UDPAddr, p mqttsn.Packet) {
+	switch p := p.(type) {
+	case *mqttsn.Searchgw:
+		g.send(addr, &mqttsn.Gwinfo{GwID: g.id})
+		return
+	case *mqttsn.Connect:
+		g.connect(addr, p)
+		return
+	case *mqttsn.Publish:
+		if p.Flags.Qos == mqttsn.QosM1 {
+			g.publish(addr, p)
+			return
+		}
+	}
+	v, ok := g.conns.Load(addr.String())
+	if !ok {
+		log.Debugf("mqttsn: not connected addr=%s", addr)
+		return
+	}
+	v.(*snConn).handle(p)
+}
+
+// Handle connect, connection of the same address is replaced
+func (g *gateway) connect(addr *net.UDPAddr, p *mqttsn.Connect) {
+	if p.ProtocolID != mqttsn.ProtocolID {
+		g.send(addr, &mqttsn.Connack{ReturnCode: mqttsn.RejectedNotSupported})
+		return
+	}
+	// server is stopping, clients come back to another gateway or later
+	if g.server.draining.Load() {
+		g.send(addr, &mqttsn.Connack{ReturnCode: mqttsn.RejectedCongestion})
+		return
+	}
+	if v, ok := g.conns.Load(addr.String()); ok {
+		old := v.(*snConn)
+		if old.resume(p) {
+			return
+		}
+		old.quiet()
+		old.Close()
+	}
+	sc := newSnConn(g, addr, p)
+	g.conns.Store(addr.String(), sc)
+	if p.Flags.Will {
+		sc.send(&mqttsn.WillTopicReq{})
+		return
+	}
+	sc.start()
+}
+
+// Publish qos -1 message without connection, only predefined and short topics are allowed
+func (g *gateway) publish(addr *net.UDPAddr, p *mqttsn.Publish) {
+	if !g.qosM1 {
+		log.Debugf("mqttsn: qos -1 publish not allowed addr=%s", addr)
+		return
+	}
+	var name string
+	switch p.Flags.TopicIDType {
+	case mqttsn.TopicPredefined:
+		name = g.topics[p.TopicID]
+	case mqttsn.TopicShort:
+		name = mqttsn.ShortTopicName(p.TopicID)
+	}
+	if name == "" || strings.ContainsAny(name, "+#") || p.Flags.Retain && !Cfg.Mqtt.RetainAvailable {
+		log.Debugf("mqttsn: invalid qos -1 publish addr=%s topic=%d", addr, p.TopicID)
+		return
+	}
+	if !g.server.authorize(g.qosM1Client(addr), AclPublish, name) {
+		log.Debugf("mqttsn: qos -1 publish not authorized addr=%s topic=%s", addr, name)
+		return
+	}
+	g.server.publish("", &packets.Publish{
+		FixHeader: &packets.FixHeader{
+			PacketType: packets.PUBLISH,
+			Qos:        packets.Qos0,
+			Retain:     p.Flags.Retain,
+		},
+		Version:   packets.V5,
+		TopicName: name,
+		Payload:   p.Data,
+	})
+}
+
+// Client of qos -1 publish without connection, acl rules match the configured identity and the address
+func (g *gateway) qosM1Client(addr *net.UDPAddr) *Client {
+	return &Client{ID: g.qosM1ID, server: g.server, prop: &ClientProp{IP: addr.String(), Username: g.qosM1Username}}
+}
+
+// Connection of mqtt-sn client, packets are translated between mqtt-sn and mqtt
+type snConn struct {
+	sync.Mutex
+	gateway  *gateway
+	addr     *net.UDPAddr
+	pc       *packets.Connect
+	started  bool
+	in       chan packets.Packet
+	closed   chan struct{}
+	wake     chan struct{}
+	once     sync.Once
+	deadline time.Time
+	silent   bool
+	// sleeping client
+	asleep     bool
+	awake      bool
+	duration   uint16
+	sleepUntil time.Time
+	buffered   []mqttsn.Packet
+	// registered topics
+	topics  map[uint16]string
+	ids     map[string]uint16
+	topicID uint16
+	msgID   uint16
+	// topic ids of inbound publishes and pending subscribes by message id
+	pubs map[uint16]uint16
+	subs map[uint16]*mqttsn.Subscribe
+	// packets waiting for acknowledgement of client, and registers waiting for regack by topic id
+	retries map[snKey]*snRetry
+	regs    map[uint16]*snRegister
+}
+
+// Key of packet waiting for acknowledgement, by packet type and message id
+type snKey struct {
+	typ byte
+	id  uint16
+}
+
+// Packet sent to client and resent until it is acknowledged
+type snRetry struct {
+	packet mqttsn.Packet
+	count  int
+	timer  *time.Timer
+}
+
+// Register sent to client, publishes of the topic wait for the regack
+type snRegister struct {
+	msgID   uint16
+	waiting []*packets.Publish
+}
+
+// Key of packet acknowledged by client, publish of qos 0 is not acknowledged
+func ackKey(p mqttsn.Packet) (snKey, bool) {
+	switch p := p.(type) {
+	case *mqttsn.Publish:
+		return snKey{mqttsn.PUBLISH, p.MsgID}, p.Flags.Qos == packets.Qos1 || p.Flags.Qos == packets.Qos2
+	case *mqttsn.Pubrel:
+		return snKey{mqttsn.PUBREL, p.MsgID}, true
+	case *mqttsn.Register:
+		return snKey{mqttsn.REGISTER, p.MsgID}, true
+	}
+	return snKey{}, false
+}
+
+func newSnConn(g *gateway, addr *net.UDPAddr, p *mqttsn.Connect) *snConn {
+	pc := &packets.Connect{
+		FixHeader:  &packets.FixHeader{PacketType: packets.CONNECT},
+		Protocol:   "MQTT-SN",
+		Version:    packets.V5,
+		CleanStart: p.Flags.CleanSession,
+		KeepAlive:  p.Duration,
+		ClientID:   p.ClientID,
+		Properties: &packets.Properties{},
+	}
+	if !p.Flags.CleanSession {
+		sei := Cfg.Mqtt.SessionExpiryInterval
+		pc.Properties.SessionExpiryInterval = &sei
+	}
+	return &snConn{
+		gateway: g,
+		addr:    addr,
+		pc:      pc,
+		in:      make(chan packets.Packet, 64),
+		closed:  make(chan struct{}),
+		wake:    make(chan struct{}, 1),
+		topics:  make(map[uint16]string),
+		ids:     make(map[string]uint16),
+		pubs:    make(map[uint16]uint16),
+		subs:    make(map[uint16]*mqttsn.Subscribe),
+		retries: make(map[snKey]*snRetry),
+		regs:    make(map[uint16]*snRegister),
+	}
+}
+
+// Start client with the connect, will topic and will message are requested before
+func (sc *snConn) start() {
+	sc.Lock()
+	sc.started = true
+	sc.Unlock()
+	c := sc.gateway.server.NewClient(sc.gateway.server.ctx, sc)
+	c.listener = "mqttsn"
+	go c.serve()
+	sc.push(sc.pc)
+}
+
+// Sleeping client returns to active state by connect of the same client id
+func (sc *snConn) resume(p *mqttsn.Connect) bool {
+	sc.Lock()
+	if !sc.asleep || p.ClientID != sc.pc.ClientID || p.Flags.CleanSession || p.Flags.Will {
+		sc.Unlock()
+		return false
+	}
+	sc.asleep, sc.awake = false, false
+	buffered := sc.buffered
+	sc.buffered = nil
+	sc.Unlock()
+	sc.notify()
+	log.Debugf("mqttsn: active cid=%s", sc.pc.ClientID)
+	sc.send(&mqttsn.Connack{ReturnCode: mqttsn.Accepted})
+	for _, p := range buffered {
+		sc.transmit(p)
+	}
+	return true
+}
+
+// Handle packet of client
+func (sc *snConn) handle(p mqttsn.Packet) {
+	sc.Lock()
+	started := sc.started
+	sc.Unlock()
+	if !started {
+		sc.will(p)
+		return
+	}
+	switch p := p.(type) {
+	case *mqttsn.Register:
+		sc.register(p)
+	case *mqttsn.Regack:
+		sc.regack(p)
+	case *mqttsn.Publish:
+		sc.publish(p)
+	case *mqttsn.Puback:
+		sc.puback(p)
+	case *mqttsn.Pubrec:
+		sc.acked(snKey{mqttsn.PUBLISH, p.MsgID})
+		sc.push(&packets.Pubrec{Version: packets.V5, PacketID: p.MsgID})
+	case *mqttsn.Pubrel:
+		sc.push(&packets.Pubrel{Version: packets.V5, PacketID: p.MsgID})
+	case *mqttsn.Pubcomp:
+		sc.acked(snKey{mqttsn.PUBREL, p.MsgID})
+		sc.push(&packets.Pubcomp{Version: packets.V5, PacketID: p.MsgID})
+	case *mqttsn.Subscribe:
+		sc.subscribe(p)
+	case *mqttsn.Unsubscribe:
+		name, ok := sc.topicName(p.Flags.TopicIDType, p.TopicID, p.TopicName)
+		if !ok {
+			sc.send(&mqttsn.Unsuback{MsgID: p.MsgID})
+			return
+		}
+		sc.push(&packets.Unsubscribe{
+			Version:    packets.V5,
+			PacketID:   p.MsgID,
+			Topics:     []string{name},
+			Properties: &packets.Properties{},
+		})
+	case *mqttsn.Pingreq:
+		sc.ping(p)
+	case *mqttsn.Disconnect:
+		sc.disconnect(p)
+	}
+}
+
+// Complete will of connect by will topic and will message
+func (sc *snConn) will(p mqttsn.Packet) {
+	switch p := p.(type) {
+	case *mqttsn.WillTopic:
+		if p.WillTopic == "" {
+			sc.start()
+			return
+		}
+		sc.pc.WillFlag = true
+		sc.pc.WillTopic = p.WillTopic
+		sc.pc.WillQos = p.Flags.Qos
+		sc.pc.WillRetain = p.Flags.Retain
+		sc.pc.WillProperties = &packets.Properties{}
+		sc.send(&mqttsn.WillMsgReq{})
+	case *mqttsn.WillMsg:
+		if !sc.pc.WillFlag {
+			return
+		}
+		sc.pc.WillMsg = string(p.WillMsg)
+		sc.start()
+	}
+}
+
+// Register topic name of client
+func (sc *snConn) register(p *mqttsn.Register) {
+	if p.TopicName == "" || strings.ContainsAny(p.TopicName, "+#") {
+		sc.send(&mqttsn.Regack{MsgID: p.MsgID, ReturnCode: mqttsn.RejectedNotSupported})
+		return
+	}
+	sc.Lock()
+	id, _ := sc.topic(p.TopicName)
+	sc.Unlock()
+	if id == 0 {
+		log.Debugf("mqttsn: topic ids exhausted cid=%s topic=%s", sc.pc.ClientID, p.TopicName)
+		sc.send(&mqttsn.Regack{MsgID: p.MsgID, ReturnCode: mqttsn.RejectedCongestion})
+		return
+	}
+	sc.send(&mqttsn.Regack{TopicID: id, MsgID: p.MsgID, ReturnCode: mqttsn.Accepted})
+}
+
+// Topic id of registered topic name, returns true when it is newly registered,
+// id 0 is returned when all topic ids are in use
+func (sc *snConn) topic(name string) (uint16, bool) {
+	if id, ok := sc.ids[name]; ok {
+		return id, false
+	}
+	if len(sc.topics) >= 0xFFFE {
+		return 0, false
+	}
+	for {
+		sc.topicID++
+		if sc.topicID == 0 || sc.topicID == 0xFFFF {
+			sc.topicID = 1
+		}
+		if _, ok := sc.topics[sc.topicID]; !ok {
+			break
+		}
+	}
+	sc.topics[sc.topicID] = name
+	sc.ids[name] = sc.topicID
+	return sc.topicID, true
+}
+
+// Topic name of topic id by topic id type
+func (sc *snConn) topicName(typ byte, id uint16, name string) (string, bool) {
+	switch typ {
+	case mqttsn.TopicNormal:
+		return name, name != ""
+	case mqttsn.TopicPredefined:
+		name, ok := sc.gateway.topics[id]
+		return name, ok
+	case mqttsn.TopicShort:
+		return mqttsn.ShortTopicName(id), true
+	}
+	return "", false
+}
+
+// Publish of client, topic name is the registered one for normal topic id type
+func (sc *snConn) publish(p *mqttsn.Publish) {
+	sc.Lock()
+	name, ok := sc.topics[p.TopicID]
+	if p.Flags.TopicIDType != mqttsn.TopicNormal {
+		name, ok = sc.topicName(p.Flags.TopicIDType, p.TopicID, "")
+	}
+	if ok && p.Flags.Qos > packets.Qos0 {
+		sc.pubs[p.MsgID] = p.TopicID
+	}
+	sc.Unlock()
+	if !ok {
+		log.Debugf("mqttsn: invalid topic id cid=%s topic=%d", sc.pc.ClientID, p.TopicID)
+		sc.send(&mqttsn.Puback{TopicID: p.TopicID, MsgID: p.MsgID, ReturnCode: mqttsn.RejectedInvalidTopicID})
+		return
+	}
+	pp := &packets.Publish{
+		FixHeader: &packets.FixHeader{
+			PacketType: packets.PUBLISH,
+			Dup:        p.Flags.Dup,
+			Qos:        p.Flags.Qos,
+			Retain:     p.Flags.Retain,
+		},
+		Version:   packets.V5,
+		TopicName: name,
+		PacketID:  p.MsgID,
+		Payload:   p.Data,
+	}
+	if sc.offer(pp) {
+		return
+	}
+	// server can not keep up, client publishes again after congestion
+	log.Debugf("mqttsn: publish rejected by congestion cid=%s topic=%s", sc.pc.ClientID, name)
+	if p.Flags.Qos > packets.Qos0 {
+		sc.Lock()
+		delete(sc.pubs, p.MsgID)
+		sc.Unlock()
+		sc.send(&mqttsn.Puback{TopicID: p.TopicID, MsgID: p.MsgID, ReturnCode: mqttsn.RejectedCongestion})
+	}
+}
+
+// Subscribe of client, the suback carries topic id of topic name without wildcard
+func (sc *snConn) subscribe(p *mqttsn.Subscribe) {
+	name, ok := sc.topicName(p.Flags.TopicIDType, p.TopicID, p.TopicName)
+	if !ok {
+		sc.send(&mqttsn.Suback{MsgID: p.MsgID, ReturnCode: mqttsn.RejectedInvalidTopicID})
+		return
+	}
+	qos := p.Flags.Qos
+	if qos > packets.Qos2 {
+		qos = packets.Qos0
+	}
+	sc.Lock()
+	sc.subs[p.MsgID] = p
+	sc.Unlock()
+	sc.push(&packets.Subscribe{
+		Version:       packets.V5,
+		PacketID:      p.MsgID,
+		Subscriptions: []packets.Subscription{{Topic: name, Qos: qos}},
+		Properties:    &packets.Properties{},
+	})
+}
+
+// Ping of sleeping client with client id wakes it up to receive the buffered messages
+func (sc *snConn) ping(p *mqttsn.Pingreq) {
+	sc.Lock()
+	var buffered []mqttsn.Packet
+	if sc.asleep && p.ClientID != "" {
+		sc.awake = true
+		buffered = sc.buffered
+		sc.buffered = nil
+	}
+	sc.Unlock()
+	for _, bp := range buffered {
+		sc.transmit(bp)
+	}
+	sc.push(&packets.Pingreq{})
+}
+
+// Disconnect with duration puts client to sleep, the session is kept until the sleep times out
+func (sc *snConn) disconnect(p *mqttsn.Disconnect) {
+	if p.Duration > 0 {
+		sc.Lock()
+		sc.asleep, sc.awake = true, false
+		sc.duration = p.Duration
+		sc.sleepUntil = sleepUntil(p.Duration)
+		sc.Unlock()
+		sc.notify()
+		log.Debugf("mqttsn: asleep cid=%s duration=%d", sc.pc.ClientID, p.Duration)
+		sc.send(&mqttsn.Disconnect{})
+		return
+	}
+	sc.quiet()
+	sc.send(&mqttsn.Disconnect{})
+	sc.push(&packets.Disconnect{Version: packets.V5, ReasonCode: packets.NormalDisconnection})
+}
+
+// Sleep times out at 1.5 times the duration
+func sleepUntil(d uint16) time.Time {
+	return time.Now().Add(time.Second * time.Duration(d/2+d))
+}
+
+// Packet of client for server, client is disconnected when server can not keep up with it
+func (sc *snConn) push(p packets.Packet) {
+	if !sc.offer(p) {
+		log.Debugf("mqttsn: server busy cid=%s", sc.pc.ClientID)
+		sc.Close()
+	}
+}
+
+// Packet of client for server, returns false when server can not keep up with client
+func (sc *snConn) offer(p packets.Packet) bool {
+	select {
+	case sc.in <- p:
+	case <-sc.closed:
+	default:
+		return false
+	}
+	return true
+}
+
+// Send packet to client
+func (sc *snConn) send(p mqttsn.Packet) {
+	sc.gateway.send(sc.addr, p)
+}
+
+// Send packet to client, buffered while client is asleep
+func (sc *snConn) deliver(p mqttsn.Packet) {
+	sc.Lock()
+	if sc.asleep && !sc.awake {
+		if len(sc.buffered) >= sc.gateway.maxBuffered && !sc.dropQos0() {
+			sc.Unlock()
+			sc.reject(p)
+			return
+		}
+		sc.buffered = append(sc.buffered, p)
+		sc.Unlock()
+		return
+	}
+	sc.Unlock()
+	sc.transmit(p)
+}
+
+// Reject packet not taken by full buffer, the publishes are released and the register is undone
+func (sc *snConn) reject(p mqttsn.Packet) {
+	log.Debugf("mqttsn: buffer full, packet rejected cid=%s packet=%T", sc.pc.ClientID, p)
+	switch p := p.(type) {
+	case *mqttsn.Publish:
+		sc.release(&packets.Publish{
+			FixHeader: &packets.FixHeader{PacketType: packets.PUBLISH, Qos: p.Flags.Qos},
+			PacketID:  p.MsgID,
+		})
+	case *mqttsn.Pubrel:
+		sc.push(&packets.Pubcomp{Version: packets.V5, PacketID: p.MsgID, ReasonCode: packets.PacketIDNotFound})
+	case *mqttsn.Register:
+		sc.Lock()
+		reg, ok := sc.regs[p.TopicID]
+		ok = ok && reg.msgID == p.MsgID
+		if ok {
+			delete(sc.regs, p.TopicID)
+			delete(sc.ids, sc.topics[p.TopicID])
+			delete(sc.topics, p.TopicID)
+		}
+		sc.Unlock()
+		if ok {
+			for _, pp := range reg.waiting {
+				sc.release(pp)
+			}
+		}
+	}
+}
+
+// Drop the oldest buffered qos 0 publish, returns false when there is none
+func (sc *snConn) dropQos0() bool {
+	for i, bp := range sc.buffered {
+		if pub, ok := bp.(*mqttsn.Publish); ok && pub.Flags.Qos == packets.Qos0 {
+			log.Debugf("mqttsn: buffer full, message dropped cid=%s", sc.pc.ClientID)
+			sc.buffered = append(sc.buffered[:i], sc.buffered[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Send packet to client, packets acknowledged by client are resent every retry interval
+func (sc *snConn) transmit(p mqttsn.Packet) {
+	if key, ok := ackKey(p); ok {
+		r := &snRetry{packet: p}
+		sc.Lock()
+		if old, ok := sc.retries[key]; ok && old.timer != nil {
+			old.timer.Stop()
+		}
+		if d := sc.gateway.retryInterval; d > 0 {
+			r.timer = time.AfterFunc(d, func() {
+				sc.retry(key, r)
+			})
+		}
+		sc.retries[key] = r
+		sc.Unlock()
+	}
+	sc.send(p)
+}
+
+// Resend packet not acknowledged, client is disconnected after retry count
+func (sc *snConn) retry(key snKey, r *snRetry) {
+	sc.Lock()
+	if sc.retries[key] != r {
+		sc.Unlock()
+		return
+	}
+	// sleeping client gets it again when it wakes up
+	if sc.asleep && !sc.awake {
+		delete(sc.retries, key)
+		if len(sc.buffered) >= sc.gateway.maxBuffered && !sc.dropQos0() {
+			sc.Unlock()
+			sc.reject(r.packet)
+			return
+		}
+		sc.buffered = append([]mqttsn.Packet{r.packet}, sc.buffered...)
+		sc.Unlock()
+		return
+	}
+	if r.count >= sc.gateway.retryCount {
+		delete(sc.retries, key)
+		sc.Unlock()
+		log.Debugf("mqttsn: no acknowledgement cid=%s type=%d msgid=%d", sc.pc.ClientID, key.typ, key.id)
+		sc.Close()
+		return
+	}
+	r.count++
+	if pub, ok := r.packet.(*mqttsn.Publish); ok {
+		pub.Flags.Dup = true
+	}
+	r.timer.Reset(sc.gateway.retryInterval)
+	sc.Unlock()
+	sc.send(r.packet)
+}
+
+// Stop resending acknowledged packet, returns the packet
+func (sc *snConn) acked(key snKey) mqttsn.Packet {
+	defer sc.Unlock()
+	sc.Lock()
+	r, ok := sc.retries[key]
+	if !ok {
+		return nil
+	}
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	delete(sc.retries, key)
+	return r.packet
+}
+
+// Regack of client, the publishes waiting for it are sent or released
+func (sc *snConn) regack(p *mqttsn.Regack) {
+	sc.acked(snKey{mqttsn.REGISTER, p.MsgID})
+	sc.Lock()
+	reg, ok := sc.regs[p.TopicID]
+	if !ok || reg.msgID != p.MsgID {
+		sc.Unlock()
+		return
+	}
+	delete(sc.regs, p.TopicID)
+	if p.ReturnCode != mqttsn.Accepted {
+		delete(sc.ids, sc.topics[p.TopicID])
+		delete(sc.topics, p.TopicID)
+	}
+	sc.Unlock()
+	if p.ReturnCode != mqttsn.Accepted {
+		log.Debugf("mqttsn: register rejected cid=%s topic=%d code=%d", sc.pc.ClientID, p.TopicID, p.ReturnCode)
+		for _, pp := range reg.waiting {
+			sc.release(pp)
+		}
+		return
+	}
+	for _, pp := range reg.waiting {
+		sc.outbound(pp)
+	}
+}
+
+// Puback of client, publish of unknown topic id is registered and sent again
+func (sc *snConn) puback(p *mqttsn.Puback) {
+	pub, _ := sc.acked(snKey{mqttsn.PUBLISH, p.MsgID}).(*mqttsn.Publish)
+	if p.ReturnCode == mqttsn.Accepted {
+		sc.push(&packets.Puback{Version: packets.V5, PacketID: p.MsgID})
+		return
+	}
+	log.Debugf("mqttsn: publish rejected cid=%s topic=%d code=%d", sc.pc.ClientID, p.TopicID, p.ReturnCode)
+	if pub == nil {
+		sc.push(&packets.Puback{Version: packets.V5, PacketID: p.MsgID, ReasonCode: packets.UnspecifiedError})
+		return
+	}
+	pp := &packets.Publish{
+		FixHeader: &packets.FixHeader{
+			PacketType: packets.PUBLISH,
+			Qos:        pub.Flags.Qos,
+			Retain:     pub.Flags.Retain,
+		},
+		Version:  packets.V5,
+		PacketID: pub.MsgID,
+		Payload:  pub.Data,
+	}
+	if p.ReturnCode == mqttsn.RejectedInvalidTopicID && pub.Flags.TopicIDType == mqttsn.TopicNormal {
+		sc.Lock()
+		name, ok := sc.topics[pub.TopicID]
+		if ok {
+			delete(sc.topics, pub.TopicID)
+			delete(sc.ids, name)
+		}
+		sc.Unlock()
+		if ok {
+			pp.TopicName = name
+			sc.outbound(pp)
+			return
+		}
+	}
+	sc.release(pp)
+}
+
+// Release publish not taken by client, the inflight window of qos 1 and 2 is freed
+func (sc *snConn) release(pp *packets.Publish) {
+	switch pp.FixHeader.Qos {
+	case packets.Qos1:
+		sc.push(&packets.Puback{Version: packets.V5, PacketID: pp.PacketID, ReasonCode: packets.UnspecifiedError})
+	case packets.Qos2:
+		sc.push(&packets.Pubrec{Version: packets.V5, PacketID: pp.PacketID, ReasonCode: packets.UnspecifiedError})
+	}
+}
+
+// No disconnect is sent to client on close
+func (sc *snConn) quiet() {
+	sc.Lock()
+	sc.silent = true
+	sc.Unlock()
+}
+
+// Wake up read for the changed deadline
+func (sc *snConn) notify() {
+	select {
+	case sc.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ReadPacket of client, deadline of sleeping client is the sleep duration
+func (sc *snConn) ReadPacket() (packets.Packet, error) {
+	for {
+		sc.Lock()
+		d := sc.deadline
+		if sc.asleep {
+			d = sc.sleepUntil
+		}
+		sc.Unlock()
+		timer := time.NewTimer(time.Until(d))
+		if d.IsZero() {
+			timer.Stop()
+		}
+		select {
+		case p := <-sc.in:
+			timer.Stop()
+			return p, nil
+		case <-sc.closed:
+			timer.Stop()
+			return nil, net.ErrClosed
+		case <-sc.wake:
+			timer.Stop()
+		case <-timer.C:
+			return nil, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// WritePacket of server, translated into mqtt-sn packets
+func (sc *snConn) WritePacket(p packets.Packet) error {
+	select {
+	case <-sc.closed:
+		return net.ErrClosed
+	default:
+	}
+	switch p := p.(type) {
+	case *packets.Connack:
+		code := returnCode(p.ReasonCode)
+		sc.send(&mqttsn.Connack{ReturnCode: code})
+		if code != mqttsn.Accepted {
+			sc.quiet()
+			sc.Close()
+		}
+	case *packets.Publish:
+		sc.outbound(p)
+	case *packets.Puback:
+		sc.Lock()
+		tid := sc.pubs[p.PacketID]
+		delete(sc.pubs, p.PacketID)
+		sc.Unlock()
+		sc.send(&mqttsn.Puback{TopicID: tid, MsgID: p.PacketID, ReturnCode: returnCode(p.ReasonCode)})
+	case *packets.Pubrec:
+		sc.Lock()
+		tid := sc.pubs[p.PacketID]
+		delete(sc.pubs, p.PacketID)
+		sc.Unlock()
+		// qos 2 publish is rejected by puback
+		if p.ReasonCode >= packets.UnspecifiedError {
+			sc.send(&mqttsn.Puback{TopicID: tid, MsgID: p.PacketID, ReturnCode: returnCode(p.ReasonCode)})
+			return nil
+		}
+		sc.send(&mqttsn.Pubrec{MsgID: p.PacketID})
+	case *packets.Pubrel:
+		sc.deliver(&mqttsn.Pubrel{MsgID: p.PacketID})
+	case *packets.Pubcomp:
+		sc.send(&mqttsn.Pubcomp{MsgID: p.PacketID})
+	case *packets.Suback:
+		sc.suback(p)
+	case *packets.Unsuback:
+		sc.send(&mqttsn.Unsuback{MsgID: p.PacketID})
+	case *packets.Pingresp:
+		sc.Lock()
+		// awake client goes back to sleep after the buffered messages
+		if sc.awake {
+			sc.awake = false
+			sc.sleepUntil = sleepUntil(sc.duration)
+		}
+		sc.Unlock()
+		sc.notify()
+		sc.send(&mqttsn.Pingresp{})
+	case *packets.Disconnect:
+		sc.quiet()
+		sc.send(&mqttsn.Disconnect{})
+	}
+	return nil
+}
+
+// Publish to client, topic name is registered to client before the first publish of it
+func (sc *snConn) outbound(pp *packets.Publish) {
+	flags := mqttsn.Flags{
+		Dup:    pp.FixHeader.Dup,
+		Qos:    pp.FixHeader.Qos,
+		Retain: pp.FixHeader.Retain,
+	}
+	var tid uint16
+	if id, ok := sc.gateway.ids[pp.TopicName]; ok {
+		flags.TopicIDType, tid = mqttsn.TopicPredefined, id
+	} else if len(pp.TopicName) == 2 {
+		flags.TopicIDType, tid = mqttsn.TopicShort, mqttsn.ShortTopicID(pp.TopicName)
+	} else {
+		sc.Lock()
+		id, isNew := sc.topic(pp.TopicName)
+		if id == 0 {
+			sc.Unlock()
+			log.Debugf("mqttsn: topic ids exhausted cid=%s topic=%s", sc.pc.ClientID, pp.TopicName)
+			sc.release(pp)
+			return
+		}
+		reg, pending := sc.regs[id]
+		if isNew {
+			sc.msgID++
+			if sc.msgID == 0 {
+				sc.msgID++
+			}
+			reg, pending = &snRegister{msgID: sc.msgID}, true
+			sc.regs[id] = reg
+		}
+		// publish waits for the regack of its topic
+		if pending {
+			reg.waiting = append(reg.waiting, pp)
+		}
+		sc.Unlock()
+		if isNew {
+			sc.deliver(&mqttsn.Register{TopicID: id, MsgID: reg.msgID, TopicName: pp.TopicName})
+		}
+		if pending {
+			return
+		}
+		tid = id
+	}
+	sc.deliver(&mqttsn.Publish{
+		Flags:   flags,
+		TopicID: tid,
+		MsgID:   pp.PacketID,
+		Data:    pp.Payload,
+	})
+}
+
+// Suback of the pending subscribe
+func (sc *snConn) suback(p *packets.Suback) {
+	sc.Lock()
+	sub := sc.subs[p.PacketID]
+	delete(sc.subs, p.PacketID)
+	var tid uint16
+	if sub != nil {
+		switch {
+		case sub.Flags.TopicIDType == mqttsn.TopicPredefined:
+			tid = sub.TopicID
+		case sub.Flags.TopicIDType == mqttsn.TopicNormal && !strings.ContainsAny(sub.TopicName, "+#"):
+			tid, _ = sc.topic(sub.TopicName)
+		}
+	}
+	sc.Unlock()
+	ack := &mqttsn.Suback{TopicID: tid, MsgID: p.PacketID}
+	if len(p.Payload) == 0 || p.Payload[0] >= packets.UnspecifiedError {
+		ack.ReturnCode = mqttsn.RejectedNotSupported
+	} else {
+		ack.Flags.Qos = p.Payload[0]
+	}
+	sc.send(ack)
+}
+
+// Return code of mqtt-sn by reason code
+func returnCode(code byte) byte {
+	switch code {
+	case packets.Success:
+		return mqttsn.Accepted
+	case packets.ServerUnavailable, packets.ServerBusy, packets.QuotaExceeded, packets.RecvMaxExceeded:
+		return mqttsn.RejectedCongestion
+	case packets.TopicNameInvalid, packets.TopicAliasInvalid:
+		return mqttsn.RejectedInvalidTopicID
+	default:
+		return mqttsn.RejectedNotSupported
+	}
+}
+
+// Close connection, disconnect is sent unless client is told already
+func (sc *snConn) Close() error {
+	sc.once.Do(func() {
+		close(sc.closed)
+		sc.gateway.conns.CompareAndDelete(sc.addr.String(), sc)
+		sc.Lock()
+		silent := sc.silent
+		for _, r := range sc.retries {
+			if r.timer != nil {
+				r.timer.Stop()
+			}
+		}
+		sc.Unlock()
+		if !silent {
+			sc.send(&mqttsn.Disconnect{})
+		}
+	})
+	return nil
+}
+
+func (sc *snConn) Read([]byte) (int, error) {
+	return 0, errPacketConn
+}
+
+func (sc *snConn) Write([]byte) (int, error) {
+	return 0, errPacketConn
+}
+
+func (sc *snConn) LocalAddr() net.Addr {
+	return sc.gateway.conn.LocalAddr()
+}
+
+func (sc *snConn) RemoteAddr() net.Addr {
+	return sc.addr
+}
+
+func (sc *snConn) SetDeadline(t time.Time) error {
+	return sc.SetReadDeadline(t)
+}
+
+func (sc *snConn) SetReadDeadline(t time.Time) error {
+	sc.Lock()
+	sc.deadline = t
+	sc.Unlock()
+	sc.notify()
+	return nil
+}
+
+func (sc *snConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	. "github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/pkg/mqttsn"
+	"github.com/laomar/gomq/pkg/packets"
+	"net"
+	"testing"
+)
+
+func TestSnBufferFull(t *testing.T) {
+	sc := newSnConn(&gateway{maxBuffered: 2}, nil, &mqttsn.Connect{ClientID: "c", Flags: mqttsn.Flags{CleanSession: true}})
+	sc.asleep = true
+	pub := func(qos byte, id uint16) *mqttsn.Publish {
+		return &mqttsn.Publish{Flags: mqttsn.Flags{Qos: qos}, TopicID: 1, MsgID: id}
+	}
+	sc.deliver(pub(1, 1))
+	sc.deliver(pub(0, 0))
+
+	// qos 0 publish makes room, then packets are rejected
+	sc.deliver(pub(2, 3))
+	sc.deliver(&mqttsn.Pubrel{MsgID: 4})
+	sc.outbound(&packets.Publish{
+		FixHeader: &packets.FixHeader{PacketType: packets.PUBLISH, Qos: packets.Qos1},
+		TopicName: "a/b",
+		PacketID:  5,
+	})
+	sc.deliver(pub(0, 0))
+	sc.deliver(pub(1, 6))
+
+	if len(sc.buffered) != 2 || sc.buffered[0].(*mqttsn.Publish).MsgID != 1 || sc.buffered[1].(*mqttsn.Publish).MsgID != 3 {
+		t.Fatalf("buffered %v, want publishes 1 and 3", sc.buffered)
+	}
+	if len(sc.regs) != 0 || len(sc.topics) != 0 || len(sc.ids) != 0 {
+		t.Fatalf("rejected register is kept")
+	}
+	want := []packets.Packet{
+		&packets.Pubcomp{Version: packets.V5, PacketID: 4, ReasonCode: packets.PacketIDNotFound},
+		&packets.Puback{Version: packets.V5, PacketID: 5, ReasonCode: packets.UnspecifiedError},
+		&packets.Puback{Version: packets.V5, PacketID: 6, ReasonCode: packets.UnspecifiedError},
+	}
+	if len(sc.in) != len(want) {
+		t.Fatalf("got %d packets for server, want %d", len(sc.in), len(want))
+	}
+	for _, w := range want {
+		p := <-sc.in
+		switch w := w.(type) {
+		case *packets.Pubcomp:
+			if p, ok := p.(*packets.Pubcomp); !ok || *p != *w {
+				t.Fatalf("got %v, want %v", p, w)
+			}
+		case *packets.Puback:
+			if p, ok := p.(*packets.Puback); !ok || *p != *w {
+				t.Fatalf("got %v, want %v", p, w)
+			}
+		}
+	}
+}
+
+func TestSnTopic(t *testing.T) {
+	sc := newSnConn(&gateway{}, nil, &mqttsn.Connect{ClientID: "c", Flags: mqttsn.Flags{CleanSession: true}})
+	if id, isNew := sc.topic("a"); id != 1 || !isNew {
+		t.Fatalf("got %d %v, want new topic id 1", id, isNew)
+	}
+	if id, isNew := sc.topic("a"); id != 1 || isNew {
+		t.Fatalf("got %d %v, want registered topic id 1", id, isNew)
+	}
+
+	// wrapped counter skips the registered topic ids
+	sc.topicID = 0xFFFE
+	if id, _ := sc.topic("b"); id != 2 {
+		t.Fatalf("got %d after wrap, want 2", id)
+	}
+	for id := uint16(3); id < 0xFFFF; id++ {
+		sc.topics[id] = "x"
+	}
+	if id, isNew := sc.topic("c"); id != 0 || isNew {
+		t.Fatalf("got %d %v, want no topic id when all are in use", id, isNew)
+	}
+	if sc.topics[1] != "a" || sc.ids["a"] != 1 {
+		t.Fatalf("registered topic is overwritten")
+	}
+}
+
+func TestSnQosM1Authorize(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("10.0.0.0/8")
+	s := &Server{acl: []*aclRule{
+		{allow: true, action: AclPublish, clientID: "sensor", ipnet: lan, topics: []string{"sensors/#"}},
+		{allow: true, action: AclPublish, topics: []string{"devices/%c/#", "users/%u/#"}},
+	}}
+	tests := []struct {
+		name     string
+		username string
+		addr     string
+		topic    string
+		want     bool
+	}{
+		{"identity and address", "", "10.0.0.1:1884", "sensors/t", true},
+		{"other address", "", "192.168.0.1:1884", "sensors/t", false},
+		{"client id topic", "", "192.168.0.1:1884", "devices/sensor/t", true},
+		{"anonymous", "", "10.0.0.1:1884", "users//t", false},
+		{"username topic", "u", "10.0.0.1:1884", "users/u/t", true},
+	}
+	noMatch := Cfg.Acl.NoMatch
+	defer func() { Cfg.Acl.NoMatch = noMatch }()
+	Cfg.Acl.NoMatch = "deny"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &gateway{server: s, qosM1ID: "sensor", qosM1Username: tt.username}
+			addr, _ := net.ResolveUDPAddr("udp", tt.addr)
+			if got := s.authorize(g.qosM1Client(addr), AclPublish, tt.topic); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
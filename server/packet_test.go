@@ -0,0 +1,68 @@
+package server
+
+import (
+	. "github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/pkg/packets"
+	"net"
+	"testing"
+)
+
+func TestReadTooLarge(t *testing.T) {
+	max := Cfg.Mqtt.MaximumPacketSize
+	defer func() { Cfg.Mqtt.MaximumPacketSize = max }()
+	Cfg.Mqtt.MaximumPacketSize = 100
+	tests := []struct {
+		name   string
+		remain int
+		want   error
+	}{
+		{"within", 98, nil},
+		{"too large", 99, packets.ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			conn, peer := net.Pipe()
+			defer conn.Close()
+			defer peer.Close()
+			c := s.NewClient(s.ctx, conn)
+			c.Version = packets.V5
+			remain := tt.remain
+			go func() {
+				// only the fix header is sent, the body of too large packet is never read
+				fh := &packets.FixHeader{PacketType: packets.PINGREQ, RemainLen: remain}
+				_ = fh.Pack(peer)
+				_, _ = peer.Write(make([]byte, remain))
+			}()
+			if _, err := c.readPacket(); err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if n := s.stats.RefusedTooLarge.Load(); (n > 0) != (tt.want != nil) {
+				t.Fatalf("refused %d, want %v", n, tt.want != nil)
+			}
+		})
+	}
+}
+
+func TestWriteTooLarge(t *testing.T) {
+	s := testServer(t)
+	sub, _ := testConnect(t, s, "sub", func(pc *packets.Connect) {
+		mps, rm := uint32(32), uint16(1)
+		pc.Properties.MaximumPacketSize = &mps
+		pc.Properties.ReceiveMaximum = &rm
+	})
+	sub.subscribe(t, packets.Subscription{Qos: packets.Qos1}, "a/b")
+	pub, _ := testConnect(t, s, "pub")
+	pub.publish(t, "a/b", packets.Qos1, 1, string(make([]byte, 32)))
+	pub.recv(t)
+	sub.none(t)
+	if n := s.stats.DroppedTooLarge.Load(); n != 1 {
+		t.Fatalf("dropped %d, want 1", n)
+	}
+	// the discarded message releases the inflight window
+	pub.publish(t, "a/b", packets.Qos1, 2, "x")
+	pub.recv(t)
+	if pp, ok := sub.recv(t).(*packets.Publish); !ok || string(pp.Payload) != "x" {
+		t.Fatalf("got %+v, want publish x", pp)
+	}
+}
@@ -7,3 +7,8 @@ type Plugin interface {
 }
 
 type NewPlugin func() (Plugin, error)
+
+// Plugin reloaded on server reload
+type Reloader interface {
+	Reload() error
+}
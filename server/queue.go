@@ -1 +1,21 @@
 package server
+
+import (
+	. "github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/log"
+	"github.com/laomar/gomq/pkg/packets"
+	"github.com/laomar/gomq/store/queue"
+)
+
+// Queue message for the offline session, at is the expiry time of message
+func (s *Server) queue(cid string, pp *packets.Publish, at int64) {
+	if _, ok := s.sessions.Load(cid); !ok {
+		return
+	}
+	if pp.FixHeader.Qos == packets.Qos0 && !Cfg.Mqtt.QueueQos0 {
+		return
+	}
+	if err := s.queueStore.Push(cid, &queue.Message{Publish: pp, ExpireAt: at}); err != nil {
+		log.Debugf("queue: %v cid=%s topic=%s", err, cid, pp.TopicName)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	. "github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/pkg/packets"
+	"github.com/laomar/gomq/store/queue"
+	"testing"
+)
+
+func TestQueueOffline(t *testing.T) {
+	s := testServer(t)
+	sub, _ := testConnect(t, s, "sub", withExpiry(60))
+	sub.subscribe(t, packets.Subscription{Qos: packets.Qos1}, "a/b")
+	_ = sub.Close()
+	testOffline(t, s, "sub")
+
+	pub, _ := testConnect(t, s, "pub")
+	for i, payload := range []string{"x", "y", "z"} {
+		pub.publish(t, "a/b", packets.Qos1, uint16(i+1), payload)
+		pub.recv(t)
+	}
+	sub, ack := testConnect(t, s, "sub", withExpiry(60), resume)
+	if !ack.SessionPresent {
+		t.Fatal("got no session present, want present")
+	}
+	for _, want := range []string{"x", "y", "z"} {
+		if pp, ok := sub.recv(t).(*packets.Publish); !ok || string(pp.Payload) != want {
+			t.Fatalf("got %+v, want publish %s", pp, want)
+		}
+	}
+	sub.none(t)
+}
+
+func TestQueueDropDisconnect(t *testing.T) {
+	mqtt := Cfg.Mqtt
+	defer func() { Cfg.Mqtt = mqtt }()
+	Cfg.Mqtt.QueueDropPolicy, Cfg.Mqtt.MaxQueueLen = queue.DropDisconnect, 1
+	s := testServer(t)
+	sub, _ := testConnect(t, s, "sub", func(pc *packets.Connect) {
+		rm := uint16(1)
+		pc.Properties.ReceiveMaximum = &rm
+	})
+	sub.subscribe(t, packets.Subscription{Qos: packets.Qos1}, "a/b")
+	pub, _ := testConnect(t, s, "pub")
+	// the first is inflight, the second is queued and the third is dropped
+	for i := 1; i <= 3; i++ {
+		pub.publish(t, "a/b", packets.Qos1, uint16(i), "x")
+		pub.recv(t)
+	}
+	if _, ok := sub.recv(t).(*packets.Publish); !ok {
+		t.Fatal("want publish")
+	}
+	if pd, ok := sub.recv(t).(*packets.Disconnect); !ok || pd.ReasonCode != packets.QuotaExceeded {
+		t.Fatalf("got %+v, want disconnect %#x", pd, packets.QuotaExceeded)
+	}
+	sub.wait(t)
+}
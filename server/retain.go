@@ -1 +1,43 @@
 package server
+
+import (
+	"github.com/laomar/gomq/log"
+	"github.com/laomar/gomq/pkg/packets"
+	"github.com/laomar/gomq/store/retain"
+)
+
+// Store or clear retained message, an empty payload removes the retained message of topic
+func (s *Server) retain(pp *packets.Publish, at int64) {
+	var err error
+	if len(pp.Payload) == 0 {
+		err = s.retainStore.Del(pp.TopicName)
+	} else {
+		err = s.retainStore.Set(&retain.Message{
+			Publish: &packets.Publish{
+				FixHeader: &packets.FixHeader{
+					PacketType: packets.PUBLISH,
+					Qos:        pp.FixHeader.Qos,
+					Retain:     true,
+				},
+				TopicName:  pp.TopicName,
+				Properties: pp.Properties,
+				Payload:    pp.Payload,
+			},
+			ExpireAt: at,
+		})
+	}
+	if err != nil {
+		log.Errorf("retain: %v topic=%s", err, pp.TopicName)
+	}
+}
+
+// Send retained messages matched the subscription
+func (c *Client) sendRetained(sub *packets.Subscription) {
+	for _, m := range c.server.retainStore.Match(sub.Topic) {
+		if expired(m.ExpireAt) {
+			_ = c.server.retainStore.Del(m.TopicName)
+			continue
+		}
+		c.publish(newPublish(m.Publish, sub.Qos, true, subIDs(sub)...), "", m.ExpireAt)
+	}
+}
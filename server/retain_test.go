@@ -0,0 +1,112 @@
+package server
+
+import (
+	. "github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/pkg/packets"
+	"testing"
+)
+
+// Publish retained message and wait for its puback
+func testRetain(t *testing.T, tc *testConn, name, payload string) {
+	t.Helper()
+	tc.send(t, &packets.Publish{
+		FixHeader: &packets.FixHeader{PacketType: packets.PUBLISH, Qos: packets.Qos1, Retain: true},
+		Version:   packets.V5,
+		PacketID:  1,
+		TopicName: name,
+		Payload:   []byte(payload),
+	})
+	if _, ok := tc.recv(t).(*packets.Puback); !ok {
+		t.Fatal("retain: want puback")
+	}
+}
+
+func TestRetainHandling(t *testing.T) {
+	tests := []struct {
+		name     string
+		handling byte
+		want     []int
+	}{
+		{"send at subscribe", 0, []int{1, 1}},
+		{"send at new subscribe", 1, []int{1, 0}},
+		{"not send", 2, []int{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			pub, _ := testConnect(t, s, "pub")
+			testRetain(t, pub, "a/b", "x")
+			sub, _ := testConnect(t, s, "sub")
+			for i, want := range tt.want {
+				sub.subscribe(t, packets.Subscription{Qos: packets.Qos1, RetainHandling: tt.handling}, "a/+")
+				got := 0
+				for ; got < want; got++ {
+					pp, ok := sub.recv(t).(*packets.Publish)
+					if !ok || !pp.FixHeader.Retain || string(pp.Payload) != "x" {
+						t.Fatalf("subscribe %d: got %+v, want retained x", i, pp)
+					}
+					sub.send(t, &packets.Puback{Version: packets.V5, PacketID: pp.PacketID})
+				}
+				sub.none(t)
+			}
+		})
+	}
+}
+
+func TestRetainAsPublished(t *testing.T) {
+	tests := []struct {
+		name string
+		rap  bool
+		want bool
+	}{
+		{"as published", true, true},
+		{"cleared", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			sub, _ := testConnect(t, s, "sub")
+			sub.subscribe(t, packets.Subscription{Qos: packets.Qos1, RetainAsPublished: tt.rap}, "a/b")
+			pub, _ := testConnect(t, s, "pub")
+			testRetain(t, pub, "a/b", "x")
+			if pp, ok := sub.recv(t).(*packets.Publish); !ok || pp.FixHeader.Retain != tt.want {
+				t.Fatalf("got %+v, want retain %v", pp, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetainClear(t *testing.T) {
+	s := testServer(t)
+	pub, _ := testConnect(t, s, "pub")
+	testRetain(t, pub, "a/b", "x")
+	testRetain(t, pub, "a/c", "y")
+	testRetain(t, pub, "a/b", "")
+	sub, _ := testConnect(t, s, "sub")
+	sub.subscribe(t, packets.Subscription{}, "a/#")
+	if pp, ok := sub.recv(t).(*packets.Publish); !ok || pp.TopicName != "a/c" {
+		t.Fatalf("got %+v, want retained a/c", pp)
+	}
+	sub.none(t)
+}
+
+func TestRetainNotSupported(t *testing.T) {
+	available := Cfg.Mqtt.RetainAvailable
+	defer func() { Cfg.Mqtt.RetainAvailable = available }()
+	Cfg.Mqtt.RetainAvailable = false
+	s := testServer(t)
+	pub, ack := testConnect(t, s, "pub")
+	if ra := ack.Properties.RetainAvailable; ra == nil || *ra != 0 {
+		t.Fatalf("got retain available %v, want 0", ra)
+	}
+	pub.send(t, &packets.Publish{
+		FixHeader: &packets.FixHeader{PacketType: packets.PUBLISH, Retain: true},
+		Version:   packets.V5,
+		TopicName: "a/b",
+		Payload:   []byte("x"),
+	})
+	if pd, ok := pub.recv(t).(*packets.Disconnect); !ok || pd.ReasonCode != packets.RetainNotSupported {
+		t.Fatalf("got %+v, want disconnect %#x", pd, packets.RetainNotSupported)
+	}
+	pub.wait(t)
+}
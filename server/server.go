@@ -9,6 +9,10 @@ import (
 	"github.com/laomar/gomq/log"
 	"github.com/laomar/gomq/pkg/packets"
 	"github.com/laomar/gomq/store"
+	infl "github.com/laomar/gomq/store/inflight"
+	"github.com/laomar/gomq/store/queue"
+	"github.com/laomar/gomq/store/retain"
+	sess "github.com/laomar/gomq/store/session"
 	"github.com/laomar/gomq/store/topic"
 	"github.com/pires/go-proxyproto"
 	"github.com/spf13/cobra"
@@ -21,6 +25,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -29,22 +34,43 @@ var plugins = make(map[string]Plugin)
 
 // Server struct
 type Server struct {
-	ctx        context.Context
-	cancel     context.CancelFunc
-	wg         sync.WaitGroup
-	topicStore topic.Store
-	cluster    *cluster.Cluster
-	clients    *sync.Map
-	cancelTcp  context.CancelFunc
-	cancelSsl  context.CancelFunc
-	cancelWs   context.CancelFunc
-	cancelWss  context.CancelFunc
+	ctx           context.Context
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
+	topicStore    topic.Store
+	retainStore   retain.Store
+	sessionStore  sess.Store
+	queueStore    queue.Store
+	inflightStore infl.Store
+	cluster       *cluster.Cluster
+	clients       *sync.Map
+	sessions      *sync.Map
+	shared        *shared
+	stats         Stats
+	auths         []Authenticator
+	authMethods   map[string]EnhancedAuthenticator
+	acl           []*aclRule
+	aclGen        atomic.Uint64
+	draining      atomic.Bool
+	listeners     map[string]*listening
 }
 
+// Running listener
+type listening struct {
+	key    string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var listenerNames = []string{"tcp", "tls", "ws", "wss", "mqttsn"}
+
 func New() *Server {
 	s := &Server{
-		clients: new(sync.Map),
-		cluster: cluster.New(),
+		clients:   new(sync.Map),
+		sessions:  new(sync.Map),
+		shared:    newShared(),
+		cluster:   cluster.New(),
+		listeners: make(map[string]*listening),
 	}
 	s.ctx, s.cancel = context.WithCancel(context.Background())
 	return s
@@ -54,7 +80,8 @@ func RegPlugin(name string, new NewPlugin) {
 	if _, ok := plugins[name]; !ok {
 		plugin, err := new()
 		if err != nil {
-			log.Errorf("plugin: register %s", name)
+			log.Errorf("plugin: register %s %v", name, err)
+			return
 		}
 		plugins[name] = plugin
 	}
@@ -70,6 +97,25 @@ func (s *Server) loadPlugin() error {
 	return nil
 }
 
+func (s *Server) reloadPlugin() {
+	for _, plugin := range plugins {
+		if r, ok := plugin.(Reloader); ok {
+			log.Infof("plugin: reloading %s", plugin.Name())
+			if err := r.Reload(); err != nil {
+				log.Errorf("plugin: reload %s %v", plugin.Name(), err)
+			}
+		}
+	}
+}
+
+func (s *Server) unloadPlugin() {
+	for _, plugin := range plugins {
+		if err := plugin.Unload(); err != nil {
+			log.Errorf("plugin: unload %s %v", plugin.Name(), err)
+		}
+	}
+}
+
 // Init Server
 func (s *Server) Init() error {
 	var err error
@@ -82,31 +128,88 @@ func (s *Server) Init() error {
 	if s.topicStore, err = se.NewTopicStore(); err != nil {
 		log.Fatalf("store: topic %v", err)
 	}
+	if s.retainStore, err = se.NewRetainStore(); err != nil {
+		log.Fatalf("store: retain %v", err)
+	}
+	if err = s.retainStore.Init(); err != nil {
+		log.Fatalf("store: retain %v", err)
+	}
+	if s.queueStore, err = se.NewQueueStore(); err != nil {
+		log.Fatalf("store: queue %v", err)
+	}
+	if s.inflightStore, err = se.NewInflightStore(); err != nil {
+		log.Fatalf("store: inflight %v", err)
+	}
+	if s.sessionStore, err = se.NewSessionStore(); err != nil {
+		log.Fatalf("store: session %v", err)
+	}
+	if err = s.loadSessions(); err != nil {
+		log.Fatalf("store: session %v", err)
+	}
 
-	return s.loadPlugin()
+	if err = s.loadPlugin(); err != nil {
+		return err
+	}
+	if err = s.loadAcl(); err != nil {
+		return err
+	}
+	return s.loadAuth()
 }
 
 func (s *Server) NewClient(ctx context.Context, conn net.Conn) *Client {
 	c := &Client{
-		server: s,
-		conn:   conn,
-		Status: Connecting,
-		in:     make(chan packets.Packet, 16),
-		out:    make(chan packets.Packet, 16),
-		prop:   new(ClientProp),
+		server:   s,
+		conn:     conn,
+		Status:   Connecting,
+		in:       make(chan packets.Packet, 16),
+		out:      make(chan packets.Packet, 16),
+		prop:     new(ClientProp),
+		aclCache: make(map[string]bool),
+		done:     make(chan struct{}),
 	}
 	c.ctx, c.cancel = context.WithCancel(ctx)
 	return c
 }
 
-// TCP server
-func (s *Server) tcp() {
-	s.wg.Add(1)
-	defer s.wg.Done()
-	lc, ok := config.Cfg.Listeners["tcp"]
-	if !ok || !lc.Enable {
-		return
+// Route publish message of client to the matched local clients
+func (s *Server) publish(cid string, pp *packets.Publish) {
+	at := expireAt(pp)
+	if pp.FixHeader.Retain {
+		s.retain(pp, at)
 	}
+	s.publishShare(cid, pp, at)
+	subs := s.topicStore.Match(pp.TopicName)
+	for id, ss := range subs {
+		var qos byte
+		retain := false
+		matched := make([]*packets.Subscription, 0, len(ss))
+		for _, sub := range ss {
+			// no local subscription does not receive messages of its own client
+			if sub.NoLocal && id == cid {
+				continue
+			}
+			matched = append(matched, sub)
+			if sub.Qos > qos {
+				qos = sub.Qos
+			}
+			if sub.RetainAsPublished {
+				retain = pp.FixHeader.Retain
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		if v, ok := s.clients.Load(id); ok {
+			v.(*Client).publish(newPublish(pp, qos, retain, subIDs(matched...)...), "", at)
+		} else {
+			s.queue(id, newPublish(pp, qos, retain, subIDs(matched...)...), at)
+		}
+	}
+}
+
+// TCP server
+func (s *Server) tcp(ctx context.Context) {
+	lc := config.Cfg.Listeners["tcp"]
 	ln, err := net.Listen("tcp", lc.Addr)
 	if err != nil {
 		log.Errorf("tcp: %v", err)
@@ -119,8 +222,6 @@ func (s *Server) tcp() {
 		pln = ln
 	}
 	defer pln.Close()
-	var ctx context.Context
-	ctx, s.cancelTcp = context.WithCancel(s.ctx)
 	go func() {
 		for {
 			select {
@@ -131,7 +232,8 @@ func (s *Server) tcp() {
 				if err != nil {
 					continue
 				}
-				c := s.NewClient(ctx, conn)
+				c := s.NewClient(s.ctx, conn)
+				c.listener = "tcp"
 				go c.serve()
 			}
 		}
@@ -142,37 +244,27 @@ func (s *Server) tcp() {
 }
 
 // TLS TCP server
-func (s *Server) tls() {
-	s.wg.Add(1)
-	defer s.wg.Done()
-	lc, ok := config.Cfg.Listeners["tls"]
-	if !ok || !lc.Enable {
-		return
-	}
-	var cert tls.Certificate
-	var err error
-	cert, err = tls.LoadX509KeyPair(lc.TLSCert, lc.TLSKey)
+func (s *Server) tls(ctx context.Context) {
+	lc := config.Cfg.Listeners["tls"]
+	cfg, err := tlsConfig("tls")
 	if err != nil {
 		log.Errorf("tls: %v", err)
 		return
 	}
-	var ln net.Listener
-	ln, err = tls.Listen("tcp", lc.Addr, &tls.Config{
-		Certificates: []tls.Certificate{cert},
-	})
+	ln, err := net.Listen("tcp", lc.Addr)
 	if err != nil {
 		log.Errorf("tls: %v", err)
 		return
 	}
+	// proxy protocol header comes before tls handshake
 	var pln net.Listener
 	if lc.ProxyProtocol {
 		pln = &proxyproto.Listener{Listener: ln}
 	} else {
 		pln = ln
 	}
+	pln = tls.NewListener(pln, cfg)
 	defer pln.Close()
-	var ctx context.Context
-	ctx, s.cancelSsl = context.WithCancel(s.ctx)
 	go func() {
 		for {
 			select {
@@ -183,7 +275,8 @@ func (s *Server) tls() {
 				if err != nil {
 					continue
 				}
-				c := s.NewClient(ctx, conn)
+				c := s.NewClient(s.ctx, conn)
+				c.listener = "tls"
 				go c.serve()
 			}
 		}
@@ -194,19 +287,13 @@ func (s *Server) tls() {
 }
 
 // Websocket server
-func (s *Server) ws() {
-	s.wg.Add(1)
-	defer s.wg.Done()
-	lc, ok := config.Cfg.Listeners["ws"]
-	if !ok || !lc.Enable {
-		return
-	}
-	var ctx context.Context
-	ctx, s.cancelWs = context.WithCancel(s.ctx)
+func (s *Server) ws(ctx context.Context) {
+	lc := config.Cfg.Listeners["ws"]
 	router := http.NewServeMux()
 	router.Handle(lc.Path, websocket.Handler(func(conn *websocket.Conn) {
 		conn.PayloadType = websocket.BinaryFrame
-		c := s.NewClient(ctx, conn)
+		c := s.NewClient(s.ctx, conn)
+		c.listener = "ws"
 		c.serve()
 	}))
 	server := &http.Server{
@@ -237,31 +324,31 @@ func (s *Server) ws() {
 	}()
 	log.Infof("ws: listening [%s]", lc.Addr)
 	<-ctx.Done()
-	if err := server.Shutdown(ctx); err != nil {
+	if err := server.Close(); err != nil {
 		log.Errorf("ws: %v", err)
 	}
 	log.Info("ws: closed")
 }
 
 // Websocket ssl server
-func (s *Server) wss() {
-	s.wg.Add(1)
-	defer s.wg.Done()
-	lc, ok := config.Cfg.Listeners["wss"]
-	if !ok || !lc.Enable {
-		return
-	}
-	var ctx context.Context
-	ctx, s.cancelWss = context.WithCancel(s.ctx)
+func (s *Server) wss(ctx context.Context) {
+	lc := config.Cfg.Listeners["wss"]
 	router := http.NewServeMux()
 	router.Handle(lc.Path, websocket.Handler(func(conn *websocket.Conn) {
 		conn.PayloadType = websocket.BinaryFrame
-		c := s.NewClient(ctx, conn)
+		c := s.NewClient(s.ctx, conn)
+		c.listener = "wss"
 		c.serve()
 	}))
+	cfg, err := tlsConfig("wss")
+	if err != nil {
+		log.Errorf("wss: %v", err)
+		return
+	}
 	server := &http.Server{
 		Addr:         lc.Addr,
 		Handler:      router,
+		TLSConfig:    cfg,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 5 * time.Second,
 	}
@@ -281,34 +368,113 @@ func (s *Server) wss() {
 	}
 	defer pln.Close()
 	go func() {
-		if err := server.ServeTLS(pln, lc.TLSCert, lc.TLSKey); err != nil && err != http.ErrServerClosed {
+		if err := server.ServeTLS(pln, "", ""); err != nil && err != http.ErrServerClosed {
 			log.Errorf("wss: %v", err)
 		}
 	}()
 	log.Infof("wss: listening [%s]", lc.Addr)
 	<-ctx.Done()
-	if err := server.Shutdown(ctx); err != nil {
+	if err := server.Close(); err != nil {
 		log.Errorf("wss: %v", err)
 	}
 	log.Info("wss: closed")
 }
 
+// MQTT-SN udp gateway, clients of gateway are disconnected when it is closed
+func (s *Server) mqttsn(ctx context.Context) {
+	lc := config.Cfg.Listeners["mqttsn"]
+	addr, err := net.ResolveUDPAddr("udp", lc.Addr)
+	if err != nil {
+		log.Errorf("mqttsn: %v", err)
+		return
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		log.Errorf("mqttsn: %v", err)
+		return
+	}
+	defer conn.Close()
+	g := newGateway(s, conn)
+	go g.serve()
+	log.Infof("mqttsn: listening [%s]", lc.Addr)
+	<-ctx.Done()
+	g.close()
+	log.Info("mqttsn: closed")
+}
+
+// Start listener when enabled, clients accepted keep running after the listener is closed
+func (s *Server) listen(name string) {
+	lc, ok := config.Cfg.Listeners[name]
+	if !ok || !lc.Enable {
+		return
+	}
+	var serve func(context.Context)
+	switch name {
+	case "tcp":
+		serve = s.tcp
+	case "tls":
+		serve = s.tls
+	case "ws":
+		serve = s.ws
+	case "wss":
+		serve = s.wss
+	case "mqttsn":
+		serve = s.mqttsn
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	l := &listening{
+		key:    listenerKey(name),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	s.listeners[name] = l
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(l.done)
+		serve(ctx)
+	}()
+}
+
+// Close listener and wait for its port to be released
+func (s *Server) unlisten(name string) {
+	if l, ok := s.listeners[name]; ok {
+		l.cancel()
+		<-l.done
+		delete(s.listeners, name)
+	}
+}
+
+// Key of listener config, certificate files are taken by modify time
+func listenerKey(name string) string {
+	lc, ok := config.Cfg.Listeners[name]
+	if !ok || !lc.Enable {
+		return ""
+	}
+	key := fmt.Sprintf("%+v", *lc)
+	for _, f := range []string{lc.CACert, lc.TLSCert, lc.TLSKey, lc.CRL} {
+		if fi, err := os.Stat(f); err == nil {
+			key += fi.ModTime().String()
+		}
+	}
+	return key
+}
+
 // Start server
 func (s *Server) Start() {
 	// signal
-	stop := make(chan os.Signal)
+	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	reload := make(chan os.Signal)
+	reload := make(chan os.Signal, 1)
 	signal.Notify(reload, syscall.SIGHUP)
 
 	log.Info("gomq: starting...")
 	if err := s.cluster.Start(); err != nil {
 		log.Fatalf("cluster: %v", err)
 	}
-	go s.tcp()
-	go s.tls()
-	go s.ws()
-	go s.wss()
+	for _, name := range listenerNames {
+		s.listen(name)
+	}
 	go s.Pprof()
 
 	for {
@@ -325,27 +491,127 @@ func (s *Server) Start() {
 	}
 }
 
-// Stop server
+// Disconnect client of id by admin, returns false when client is not connected
+func (s *Server) Kick(cid string, code byte, props *packets.Properties) bool {
+	v, ok := s.clients.Load(cid)
+	if !ok {
+		return false
+	}
+	log.Debugf("mqtt: kick cid=%s reason=%#x", cid, code)
+	v.(*Client).Kick(code, props)
+	return true
+}
+
+// Disconnect clients at drain rate, clients are told to use another server when server reference is set
+func (s *Server) drain() {
+	dc := config.Cfg.Drain
+	var code byte = packets.ServerShuttingDown
+	var props *packets.Properties
+	if dc.ServerReference != "" {
+		code = packets.UseAnotherServer
+		props = &packets.Properties{ServerReference: dc.ServerReference}
+	}
+	clients := make([]*Client, 0)
+	s.clients.Range(func(_, v any) bool {
+		clients = append(clients, v.(*Client))
+		return true
+	})
+	log.Infof("gomq: draining %d clients", len(clients))
+
+	ctx, cancel := context.WithTimeout(context.Background(), dc.Timeout)
+	defer cancel()
+	var tick <-chan time.Time
+	if dc.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(dc.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	n := 0
+kick:
+	for _, c := range clients {
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				break kick
+			}
+		}
+		go c.Kick(code, props)
+		n++
+	}
+	// stores are closed after drain, wait until no packet of clients is handled
+	for _, c := range clients {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			// the sessions of clients left are saved on close
+			c.close()
+			<-c.done
+		}
+	}
+	log.Infof("gomq: drained %d clients", n)
+}
+
+// Close stores, persistent session state is flushed
+func (s *Server) closeStore() {
+	_ = s.sessionStore.Close()
+	_ = s.queueStore.Close()
+	_ = s.inflightStore.Close()
+	_ = s.retainStore.Close()
+	_ = s.topicStore.Close()
+}
+
+// Stop server, listeners are closed first and then clients are drained
 func (s *Server) Stop() {
 	defer s.cancel()
+	log.Info("gomq: stopping...")
+	s.draining.Store(true)
+	// mqtt-sn clients can not outlive the gateway, it is closed after they are drained
+	for _, name := range listenerNames {
+		if name != "mqttsn" {
+			s.unlisten(name)
+		}
+	}
+	s.drain()
+	s.unlisten("mqttsn")
 	_ = s.cluster.Stop()
+	s.unloadPlugin()
+	s.closeStore()
 	_ = os.Remove(config.Cfg.PidFile)
 }
 
-// Reload server
+// Reload server, only the listeners whose config changed are rebound and connections are kept
 func (s *Server) Reload() {
 	log.Info("gomq: reload...")
-	config.Cfg.Parse()
-
-	s.cancelTcp()
-	s.cancelSsl()
-	s.cancelWs()
-	s.cancelWss()
-	time.Sleep(time.Second)
-	go s.tcp()
-	go s.tls()
-	go s.ws()
-	go s.wss()
+	if err := config.Cfg.Reload(); err != nil {
+		log.Errorf("gomq: reload %v", err)
+		return
+	}
+	s.reloadPlugin()
+	if err := s.loadAcl(); err != nil {
+		log.Errorf("gomq: reload %v", err)
+	}
+	if err := s.loadAuth(); err != nil {
+		log.Errorf("gomq: reload %v", err)
+	}
+
+	for _, name := range listenerNames {
+		if l, ok := s.listeners[name]; ok {
+			select {
+			case <-l.done:
+				// listener failed, bind again
+			default:
+				if l.key == listenerKey(name) {
+					continue
+				}
+			}
+		} else if listenerKey(name) == "" {
+			continue
+		}
+		log.Infof("gomq: rebind %s", name)
+		s.unlisten(name)
+		s.listen(name)
+	}
 }
 
 // Pprof Listen
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"errors"
+	"github.com/laomar/gomq/pkg/packets"
+	infl "github.com/laomar/gomq/store/inflight"
+	"github.com/laomar/gomq/store/queue"
+	"github.com/laomar/gomq/store/retain"
+	sess "github.com/laomar/gomq/store/session"
+	"github.com/laomar/gomq/store/topic"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errTestConn = errors.New("test: connection closed")
+
+// Connection of tests exchanging packets with the client through channels
+type testConn struct {
+	in     chan packets.Packet
+	out    chan packets.Packet
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newTestConn() *testConn {
+	return &testConn{
+		in:     make(chan packets.Packet, 16),
+		out:    make(chan packets.Packet, 64),
+		closed: make(chan struct{}),
+	}
+}
+
+func (tc *testConn) ReadPacket() (packets.Packet, error) {
+	select {
+	case p := <-tc.in:
+		return p, nil
+	case <-tc.closed:
+		return nil, errTestConn
+	}
+}
+
+func (tc *testConn) WritePacket(p packets.Packet) error {
+	select {
+	case tc.out <- p:
+		return nil
+	case <-tc.closed:
+		return errTestConn
+	}
+}
+
+func (tc *testConn) Read([]byte) (int, error) {
+	return 0, errPacketConn
+}
+
+func (tc *testConn) Write([]byte) (int, error) {
+	return 0, errPacketConn
+}
+
+func (tc *testConn) Close() error {
+	tc.once.Do(func() { close(tc.closed) })
+	return nil
+}
+
+func (tc *testConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1883}
+}
+
+func (tc *testConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+}
+
+func (tc *testConn) SetDeadline(time.Time) error      { return nil }
+func (tc *testConn) SetReadDeadline(time.Time) error  { return nil }
+func (tc *testConn) SetWriteDeadline(time.Time) error { return nil }
+
+// Send packet to server
+func (tc *testConn) send(t *testing.T, p packets.Packet) {
+	t.Helper()
+	select {
+	case tc.in <- p:
+	case <-time.After(time.Second):
+		t.Fatalf("send %T: timeout", p)
+	}
+}
+
+// Receive packet from server
+func (tc *testConn) recv(t *testing.T) packets.Packet {
+	t.Helper()
+	select {
+	case p := <-tc.out:
+		return p
+	case <-time.After(time.Second):
+		t.Fatal("recv: timeout")
+	}
+	return nil
+}
+
+// No packet is received from server in a while
+func (tc *testConn) none(t *testing.T) {
+	t.Helper()
+	select {
+	case p := <-tc.out:
+		t.Fatalf("got %T %+v, want no packet", p, p)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Wait for the connection closed by server
+func (tc *testConn) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-tc.closed:
+	case <-time.After(time.Second):
+		t.Fatal("close: timeout")
+	}
+}
+
+// Server with ram stores, it is not started and has no listener
+func testServer(t *testing.T) *Server {
+	s := New()
+	s.topicStore = topic.NewRam()
+	s.retainStore = retain.NewRam()
+	s.queueStore = queue.NewRam()
+	s.inflightStore = infl.NewRam()
+	s.sessionStore = sess.NewRam()
+	t.Cleanup(s.cancel)
+	return s
+}
+
+// Connect v5 client of id to server, opts change the connect packet
+func testConnect(t *testing.T, s *Server, cid string, opts ...func(*packets.Connect)) (*testConn, *packets.Connack) {
+	t.Helper()
+	pc := &packets.Connect{
+		FixHeader:  &packets.FixHeader{PacketType: packets.CONNECT},
+		Protocol:   "MQTT",
+		Version:    packets.V5,
+		CleanStart: true,
+		ClientID:   cid,
+		Properties: &packets.Properties{},
+	}
+	for _, opt := range opts {
+		opt(pc)
+	}
+	tc := newTestConn()
+	c := s.NewClient(s.ctx, tc)
+	go c.serve()
+	tc.send(t, pc)
+	ack, ok := tc.recv(t).(*packets.Connack)
+	if !ok {
+		t.Fatal("connect: want connack")
+	}
+	t.Cleanup(func() { _ = tc.Close() })
+	// the client is stored once the connack is written
+	for i := 0; ack.ReasonCode == packets.Success && i < 100; i++ {
+		if v, ok := s.clients.Load(c.ID); ok && v == c {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return tc, ack
+}
+
+// Subscribe topic filters with options of sub
+func (tc *testConn) subscribe(t *testing.T, sub packets.Subscription, filters ...string) *packets.Suback {
+	t.Helper()
+	ps := &packets.Subscribe{
+		FixHeader:  &packets.FixHeader{PacketType: packets.SUBSCRIBE, Qos: packets.Qos1},
+		Version:    packets.V5,
+		PacketID:   1,
+		Properties: &packets.Properties{},
+	}
+	for _, filter := range filters {
+		sub.Topic = filter
+		ps.Subscriptions = append(ps.Subscriptions, sub)
+	}
+	tc.send(t, ps)
+	ack, ok := tc.recv(t).(*packets.Suback)
+	if !ok {
+		t.Fatal("subscribe: want suback")
+	}
+	return ack
+}
+
+// Publish message to topic
+func (tc *testConn) publish(t *testing.T, name string, qos byte, id uint16, payload string) {
+	t.Helper()
+	tc.send(t, &packets.Publish{
+		FixHeader:  &packets.FixHeader{PacketType: packets.PUBLISH, Qos: qos},
+		Version:    packets.V5,
+		PacketID:   id,
+		TopicName:  name,
+		Properties: &packets.Properties{},
+		Payload:    []byte(payload),
+	})
+}
+
+// Wait for the session of client id closed by the server
+func testOffline(t *testing.T, s *Server, cid string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if v, ok := s.sessions.Load(cid); ok {
+			ss := v.(*session)
+			ss.Lock()
+			offline := ss.client == nil
+			ss.Unlock()
+			if offline {
+				return
+			}
+		} else if _, ok := s.clients.Load(cid); !ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("offline %s: timeout", cid)
+}
+
+// Connect option of session expiry interval
+func withExpiry(sei uint32) func(*packets.Connect) {
+	return func(pc *packets.Connect) {
+		pc.Properties.SessionExpiryInterval = &sei
+	}
+}
+
+// Connect option resuming the previous session
+func resume(pc *packets.Connect) {
+	pc.CleanStart = false
+}
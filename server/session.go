@@ -1 +1,227 @@
 package server
+
+import (
+	"github.com/laomar/gomq/log"
+	"github.com/laomar/gomq/pkg/packets"
+	infl "github.com/laomar/gomq/store/inflight"
+	"github.com/laomar/gomq/store/queue"
+	sess "github.com/laomar/gomq/store/session"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session state kept across connections
+type session struct {
+	sync.Mutex
+	id        string
+	client    *Client
+	expired   bool
+	timer     *time.Timer
+	will      *packets.Publish
+	willTimer *time.Timer
+	inflight  *inflight
+	received  *received
+}
+
+func newSession(cid string, q queue.Store, ms infl.Store) *session {
+	return &session{
+		id:       cid,
+		inflight: newInflight(cid, q, ms),
+		received: newReceived(cid, ms),
+	}
+}
+
+// Load persistent sessions and their subscriptions on start
+func (s *Server) loadSessions() error {
+	ss, err := s.sessionStore.All()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	cids := make([]string, 0, len(ss))
+	for _, se := range ss {
+		remain := int64(se.ExpiryInterval)
+		if se.DisconnectedAt > 0 {
+			remain -= now - se.DisconnectedAt
+		}
+		if se.ExpiryInterval != math.MaxUint32 && remain <= 0 {
+			_, _ = s.topicStore.UnsubscribeAll(se.ClientID)
+			_ = s.queueStore.Clear(se.ClientID)
+			_ = s.inflightStore.Clear(se.ClientID)
+			_ = s.sessionStore.Del(se.ClientID)
+			continue
+		}
+		ns := newSession(se.ClientID, s.queueStore, s.inflightStore)
+		ms, err := s.inflightStore.All(se.ClientID)
+		if err != nil {
+			return err
+		}
+		outbound, inbound := make([]*infl.Message, 0, len(ms)), make([]*infl.Message, 0)
+		for _, m := range ms {
+			if m.Inbound {
+				inbound = append(inbound, m)
+			} else {
+				outbound = append(outbound, m)
+			}
+		}
+		ns.inflight.load(outbound)
+		ns.received.load(inbound)
+		if se.ExpiryInterval != math.MaxUint32 {
+			ns.timer = time.AfterFunc(time.Duration(remain)*time.Second, func() {
+				s.expireSession(ns)
+			})
+		}
+		s.sessions.Store(se.ClientID, ns)
+		cids = append(cids, se.ClientID)
+	}
+	log.Infof("session: loaded %d", len(cids))
+	if err = s.queueStore.Init(cids...); err != nil {
+		return err
+	}
+	return s.topicStore.Init(cids...)
+}
+
+// Open session for client, returns whether a previous session is present
+func (s *Server) openSession(c *Client) bool {
+	// take over the connection with the same client id
+	if v, ok := s.clients.Load(c.ID); ok {
+		old := v.(*Client)
+		old.Kick(packets.SessionTakenOver, nil)
+		select {
+		case <-old.ctx.Done():
+		case <-time.After(time.Second):
+			old.close()
+		}
+		log.Debugf("session: taken over cid=%s", c.ID)
+	}
+
+	var ss *session
+	if v, ok := s.sessions.Load(c.ID); ok {
+		ss = v.(*session)
+		ss.Lock()
+		if ss.timer != nil {
+			ss.timer.Stop()
+		}
+		if ss.willTimer != nil {
+			ss.willTimer.Stop()
+			ss.will = nil
+		}
+		if ss.expired || c.prop.CleanStart {
+			ss.expired = true
+			ss.Unlock()
+			s.removeSession(ss)
+			ss = nil
+		} else {
+			ss.client = c
+			ss.Unlock()
+		}
+	}
+	present := ss != nil
+	if ss == nil {
+		ss = newSession(c.ID, s.queueStore, s.inflightStore)
+		ss.client = c
+		s.sessions.Store(c.ID, ss)
+	}
+	ss.inflight.resize(c.prop.MaxInflight)
+	ss.inflight.setPersist(c.prop.SessionExpiryInterval > 0)
+	ss.received.setPersist(c.prop.SessionExpiryInterval > 0)
+	c.session = ss
+
+	if c.prop.SessionExpiryInterval > 0 {
+		err := s.sessionStore.Set(&sess.Session{
+			ClientID:       c.ID,
+			ExpiryInterval: c.prop.SessionExpiryInterval,
+		})
+		if err != nil {
+			log.Errorf("session: %v cid=%s", err, c.ID)
+		}
+	}
+	return present
+}
+
+// Close session of client, the session is removed when it expires
+func (s *Server) closeSession(c *Client) {
+	ss := c.session
+	ss.Lock()
+	if ss.client != c {
+		ss.Unlock()
+		return
+	}
+	ss.client = nil
+	expiry := c.prop.SessionExpiryInterval
+	if expiry == 0 {
+		ss.expired = true
+		ss.Unlock()
+		s.removeSession(ss)
+		s.sendWill(c.ID, c.will)
+		return
+	}
+	if expiry != math.MaxUint32 {
+		ss.timer = time.AfterFunc(time.Duration(expiry)*time.Second, func() {
+			s.expireSession(ss)
+		})
+	}
+	will := c.will
+	if delay := c.prop.WillDelayInterval; will != nil && delay > 0 {
+		// the will is sent at the earlier of will delay interval and session end
+		if delay > expiry {
+			delay = expiry
+		}
+		ss.will = will
+		ss.willTimer = time.AfterFunc(time.Duration(delay)*time.Second, func() {
+			s.delayWill(ss)
+		})
+		will = nil
+	}
+	ss.Unlock()
+	s.sendWill(c.ID, will)
+
+	err := s.sessionStore.Set(&sess.Session{
+		ClientID:       c.ID,
+		ExpiryInterval: expiry,
+		DisconnectedAt: time.Now().Unix(),
+		Reason:         c.reason,
+	})
+	if err != nil {
+		log.Errorf("session: %v cid=%s", err, c.ID)
+	}
+}
+
+func (s *Server) expireSession(ss *session) {
+	ss.Lock()
+	if ss.client != nil || ss.expired {
+		ss.Unlock()
+		return
+	}
+	ss.expired = true
+	ss.Unlock()
+	s.removeSession(ss)
+	log.Debugf("session: expired cid=%s", ss.id)
+}
+
+func (s *Server) removeSession(ss *session) {
+	s.sessions.CompareAndDelete(ss.id, ss)
+	topics, err := s.topicStore.UnsubscribeAll(ss.id)
+	if err != nil {
+		log.Errorf("session: %v cid=%s", err, ss.id)
+	}
+	// shared subscriptions are not synced to cluster
+	for _, filter := range topics {
+		if !strings.HasPrefix(filter, "$share/") && !s.topicStore.Subscribed(filter) {
+			s.cluster.Unsubscribe(ss.id, filter)
+		}
+	}
+	s.shared.leaveAll(ss.id, s.topicStore.Subscribed)
+	s.redispatch(ss)
+	if err := s.queueStore.Clear(ss.id); err != nil {
+		log.Errorf("session: %v cid=%s", err, ss.id)
+	}
+	if err := s.inflightStore.Clear(ss.id); err != nil {
+		log.Errorf("session: %v cid=%s", err, ss.id)
+	}
+	if err := s.sessionStore.Del(ss.id); err != nil {
+		log.Errorf("session: %v cid=%s", err, ss.id)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"github.com/laomar/gomq/pkg/packets"
+	"testing"
+	"time"
+)
+
+func TestSessionTakeover(t *testing.T) {
+	s := testServer(t)
+	old, _ := testConnect(t, s, "c", withExpiry(60))
+	old.subscribe(t, packets.Subscription{Qos: packets.Qos1}, "a/b")
+	tc, ack := testConnect(t, s, "c", withExpiry(60), resume)
+	if pd, ok := old.recv(t).(*packets.Disconnect); !ok || pd.ReasonCode != packets.SessionTakenOver {
+		t.Fatalf("got %+v, want disconnect %#x", pd, packets.SessionTakenOver)
+	}
+	old.wait(t)
+	if !ack.SessionPresent {
+		t.Fatal("got no session present, want present")
+	}
+	// the subscription of session is taken over
+	pub, _ := testConnect(t, s, "pub")
+	pub.publish(t, "a/b", packets.Qos1, 1, "x")
+	if pp, ok := tc.recv(t).(*packets.Publish); !ok || string(pp.Payload) != "x" {
+		t.Fatalf("got %+v, want publish x", pp)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	tests := []struct {
+		name       string
+		expiry     uint32
+		disconnect *uint32
+		wait       time.Duration
+		want       bool
+	}{
+		{"ends on close", 0, nil, 0, false},
+		{"kept", 60, nil, 0, true},
+		{"expired", 1, nil, 1200 * time.Millisecond, false},
+		{"ended by disconnect", 60, new(uint32), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			tc, _ := testConnect(t, s, "c", withExpiry(tt.expiry))
+			tc.subscribe(t, packets.Subscription{Qos: packets.Qos1}, "a/b")
+			if tt.disconnect != nil {
+				tc.send(t, &packets.Disconnect{
+					Version:    packets.V5,
+					Properties: &packets.Properties{SessionExpiryInterval: tt.disconnect},
+				})
+			} else {
+				_ = tc.Close()
+			}
+			testOffline(t, s, "c")
+			time.Sleep(tt.wait)
+			_, ack := testConnect(t, s, "c", withExpiry(tt.expiry), resume)
+			if ack.SessionPresent != tt.want {
+				t.Fatalf("got session present %v, want %v", ack.SessionPresent, tt.want)
+			}
+		})
+	}
+}
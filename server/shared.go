@@ -0,0 +1,186 @@
+package server
+
+import (
+	. "github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/log"
+	"github.com/laomar/gomq/pkg/packets"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// Shared subscription strategy
+const (
+	Random        = "random"
+	RoundRobin    = "round_robin"
+	Sticky        = "sticky"
+	HashClientID  = "hash_clientid"
+	HashTopic     = "hash_topic"
+	LeastInflight = "least_inflight"
+)
+
+// Shared subscription dispatch state, keyed by the shared topic filter
+type shared struct {
+	sync.Mutex
+	next   map[string]int
+	sticky map[string]string
+}
+
+func newShared() *shared {
+	return &shared{
+		next:   make(map[string]int),
+		sticky: make(map[string]string),
+	}
+}
+
+// Group shared subscriptions by shared topic filter
+func shareGroups(subs map[string][]*packets.Subscription) map[string]map[string]*packets.Subscription {
+	groups := make(map[string]map[string]*packets.Subscription)
+	for cid, ss := range subs {
+		for _, sub := range ss {
+			if _, ok := groups[sub.Topic]; !ok {
+				groups[sub.Topic] = make(map[string]*packets.Subscription)
+			}
+			groups[sub.Topic][cid] = sub
+		}
+	}
+	return groups
+}
+
+// Dispatch message to one member of each matched shared subscription group, at is the expiry time of message
+func (s *Server) publishShare(cid string, pp *packets.Publish, at int64) {
+	for share, members := range shareGroups(s.topicStore.MatchShare(pp.TopicName)) {
+		s.dispatchShare(share, members, cid, pp, at)
+	}
+}
+
+func (s *Server) dispatchShare(share string, members map[string]*packets.Subscription, cid string, pp *packets.Publish, at int64) {
+	mid := s.pickShare(share, members, cid, pp.TopicName)
+	if mid == "" {
+		return
+	}
+	sub := members[mid]
+	retain := sub.RetainAsPublished && pp.FixHeader.Retain
+	if v, ok := s.clients.Load(mid); ok {
+		v.(*Client).publish(newPublish(pp, sub.Qos, retain, subIDs(sub)...), share, at)
+	} else {
+		s.queue(mid, newPublish(pp, sub.Qos, retain, subIDs(sub)...), at)
+	}
+}
+
+// Pick a member of shared subscription group, connected members are preferred
+func (s *Server) pickShare(share string, members map[string]*packets.Subscription, cid, topic string) string {
+	cids := make([]string, 0, len(members))
+	for mid := range members {
+		if _, ok := s.clients.Load(mid); ok {
+			cids = append(cids, mid)
+		}
+	}
+	if len(cids) == 0 {
+		for mid := range members {
+			cids = append(cids, mid)
+		}
+	}
+	if len(cids) == 0 {
+		return ""
+	}
+	sort.Strings(cids)
+
+	sh := s.shared
+	sh.Lock()
+	defer sh.Unlock()
+	switch Cfg.Mqtt.SharedSubStrategy {
+	case RoundRobin:
+		i := sh.next[share] % len(cids)
+		sh.next[share] = i + 1
+		return cids[i]
+	case Sticky:
+		if mid, ok := sh.sticky[share]; ok {
+			if _, ok := members[mid]; ok {
+				if _, ok := s.clients.Load(mid); ok {
+					return mid
+				}
+			}
+		}
+		mid := cids[rand.Intn(len(cids))]
+		sh.sticky[share] = mid
+		return mid
+	case HashClientID:
+		return cids[hash(cid)%uint32(len(cids))]
+	case HashTopic:
+		return cids[hash(topic)%uint32(len(cids))]
+	case LeastInflight:
+		mid, least := cids[0], -1
+		for _, id := range cids {
+			n := 0
+			if v, ok := s.sessions.Load(id); ok {
+				n = v.(*session).inflight.len()
+			}
+			if least < 0 || n < least {
+				mid, least = id, n
+			}
+		}
+		return mid
+	default:
+		return cids[rand.Intn(len(cids))]
+	}
+}
+
+// Member leaves shared subscription group, state of the group is removed when it is empty
+func (sh *shared) leave(share, cid string, empty bool) {
+	sh.Lock()
+	defer sh.Unlock()
+	if empty || sh.sticky[share] == cid {
+		delete(sh.sticky, share)
+	}
+	if empty {
+		delete(sh.next, share)
+	}
+}
+
+// Member leaves all groups as its session is removed, subscribed reports whether a group has members
+func (sh *shared) leaveAll(cid string, subscribed func(string) bool) {
+	sh.Lock()
+	defer sh.Unlock()
+	for share, mid := range sh.sticky {
+		if mid == cid || !subscribed(share) {
+			delete(sh.sticky, share)
+		}
+	}
+	for share := range sh.next {
+		if !subscribed(share) {
+			delete(sh.next, share)
+		}
+	}
+}
+
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// Redispatch unacknowledged shared messages of the session to other members of the groups,
+// messages of groups without other members stay in the session
+func (s *Server) redispatch(ss *session) {
+	members := make(map[*inflightMsg]map[string]*packets.Subscription)
+	ms := ss.inflight.takeShared(func(m *inflightMsg) bool {
+		others := make(map[string]*packets.Subscription)
+		for mid, subs := range s.topicStore.MatchShare(m.publish.TopicName) {
+			for _, sub := range subs {
+				if mid != ss.id && sub.Topic == m.share {
+					others[mid] = sub
+				}
+			}
+		}
+		members[m] = others
+		return len(others) > 0
+	})
+	for _, m := range ms {
+		pp := m.publish
+		pp.FixHeader.Dup = false
+		log.Debugf("shared: redispatch cid=%s topic=%s share=%s", ss.id, pp.TopicName, m.share)
+		s.dispatchShare(m.share, members[m], ss.id, pp, m.expireAt)
+	}
+}
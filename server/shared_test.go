@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	. "github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/pkg/packets"
+	infl "github.com/laomar/gomq/store/inflight"
+	"github.com/laomar/gomq/store/queue"
+	"github.com/laomar/gomq/store/topic"
+	"sync"
+	"testing"
+)
+
+const testShare = "$share/g/a/b"
+
+// Server with members a, b and c of a shared subscription group, c is not connected
+func testShareServer() (*Server, map[string]*packets.Subscription) {
+	s := &Server{
+		clients:  new(sync.Map),
+		sessions: new(sync.Map),
+		shared:   newShared(),
+	}
+	members := make(map[string]*packets.Subscription)
+	for _, cid := range []string{"a", "b", "c"} {
+		members[cid] = &packets.Subscription{Topic: testShare}
+		s.sessions.Store(cid, newSession(cid, queue.NewRam(), infl.NewRam()))
+		if cid != "c" {
+			s.clients.Store(cid, &Client{ID: cid})
+		}
+	}
+	return s, members
+}
+
+func TestPickShare(t *testing.T) {
+	tests := []struct {
+		strategy string
+		want     []string
+		same     bool
+	}{
+		{RoundRobin, []string{"a", "b", "a", "b"}, false},
+		{LeastInflight, []string{"b", "b", "b", "b"}, true},
+		{Sticky, nil, true},
+		{HashClientID, nil, true},
+		{HashTopic, nil, true},
+		{Random, nil, false},
+	}
+	strategy := Cfg.Mqtt.SharedSubStrategy
+	defer func() { Cfg.Mqtt.SharedSubStrategy = strategy }()
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			Cfg.Mqtt.SharedSubStrategy = tt.strategy
+			s, members := testShareServer()
+			v, _ := s.sessions.Load("a")
+			v.(*session).inflight.push(testPublish(1), testShare, 0)
+			var first string
+			for i := 0; i < 4; i++ {
+				mid := s.pickShare(testShare, members, "p", "a/b")
+				if mid != "a" && mid != "b" {
+					t.Fatalf("pick %d: got %q, want a connected member", i, mid)
+				}
+				if i == 0 {
+					first = mid
+				}
+				if tt.same && mid != first {
+					t.Fatalf("pick %d: got %q, want %q", i, mid, first)
+				}
+				if tt.want != nil && mid != tt.want[i] {
+					t.Fatalf("pick %d: got %q, want %q", i, mid, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPickShareOffline(t *testing.T) {
+	strategy := Cfg.Mqtt.SharedSubStrategy
+	defer func() { Cfg.Mqtt.SharedSubStrategy = strategy }()
+	Cfg.Mqtt.SharedSubStrategy = RoundRobin
+	s, members := testShareServer()
+	s.clients.Delete("a")
+	s.clients.Delete("b")
+
+	// messages are queued to offline members when no member is connected
+	for i, want := range []string{"a", "b", "c", "a"} {
+		if mid := s.pickShare(testShare, members, "p", "a/b"); mid != want {
+			t.Fatalf("pick %d: got %q, want %q", i, mid, want)
+		}
+	}
+	if mid := s.pickShare(testShare, nil, "p", "a/b"); mid != "" {
+		t.Fatalf("pick of empty group: got %q", mid)
+	}
+}
+
+func TestPickShareSticky(t *testing.T) {
+	strategy := Cfg.Mqtt.SharedSubStrategy
+	defer func() { Cfg.Mqtt.SharedSubStrategy = strategy }()
+	Cfg.Mqtt.SharedSubStrategy = Sticky
+	s, members := testShareServer()
+	first := s.pickShare(testShare, members, "p", "a/b")
+
+	// sticky member is replaced once it disconnects
+	s.clients.Delete(first)
+	second := s.pickShare(testShare, members, "p", "a/b")
+	if second == first || second == "c" {
+		t.Fatalf("got %q after %q disconnected", second, first)
+	}
+
+	// and once it leaves the group
+	s.shared.leave(testShare, second, false)
+	if _, ok := s.shared.sticky[testShare]; ok {
+		t.Fatalf("sticky member is kept after leaving")
+	}
+}
+
+func TestSharedLeave(t *testing.T) {
+	tests := []struct {
+		name   string
+		cid    string
+		empty  bool
+		sticky bool
+		next   bool
+	}{
+		{"other member", "b", false, true, true},
+		{"sticky member", "a", false, false, true},
+		{"last member", "b", true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sh := newShared()
+			sh.sticky[testShare] = "a"
+			sh.next[testShare] = 1
+			sh.leave(testShare, tt.cid, tt.empty)
+			_, sticky := sh.sticky[testShare]
+			_, next := sh.next[testShare]
+			if sticky != tt.sticky || next != tt.next {
+				t.Fatalf("sticky %v next %v, want %v %v", sticky, next, tt.sticky, tt.next)
+			}
+		})
+	}
+}
+
+func TestSharedLeaveAll(t *testing.T) {
+	sh := newShared()
+	sh.sticky["$share/g/a"] = "a"
+	sh.sticky["$share/g/b"] = "b"
+	sh.sticky["$share/g/c"] = "c"
+	sh.next["$share/g/b"] = 1
+	sh.next["$share/g/c"] = 1
+	sh.leaveAll("a", func(share string) bool { return share != "$share/g/c" })
+	if len(sh.sticky) != 1 || sh.sticky["$share/g/b"] != "b" {
+		t.Fatalf("sticky %v, want only group b", sh.sticky)
+	}
+	if len(sh.next) != 1 || sh.next["$share/g/b"] != 1 {
+		t.Fatalf("next %v, want only group b", sh.next)
+	}
+}
+
+func TestRedispatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []string
+		stays   bool
+	}{
+		{"offline member", []string{"a", "c"}, false},
+		{"connected member", []string{"a", "b"}, false},
+		{"no other member", []string{"a"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := queue.NewRam()
+			s := &Server{
+				clients:    new(sync.Map),
+				sessions:   new(sync.Map),
+				shared:     newShared(),
+				topicStore: topic.NewRam(),
+				queueStore: q,
+			}
+			for _, cid := range tt.members {
+				s.sessions.Store(cid, newSession(cid, q, infl.NewRam()))
+				s.topicStore.Subscribe(cid, &packets.Subscription{Topic: testShare, ShareName: "g", Qos: packets.Qos1})
+			}
+			b := &Client{ID: "b", ctx: context.Background(), out: make(chan packets.Packet, 1), session: newSession("b", q, infl.NewRam())}
+			s.clients.Store("b", b)
+			v, _ := s.sessions.Load("a")
+			ss := v.(*session)
+			ss.inflight.push(testPublish(1), testShare, 0)
+
+			s.redispatch(ss)
+			if n := ss.inflight.len(); tt.stays != (n == 1) {
+				t.Fatalf("len %d of leaving session, stays %v", n, tt.stays)
+			}
+			if n := q.Len("c") + b.session.inflight.len(); tt.stays != (n == 0) {
+				t.Fatalf("%d messages redispatched, stays %v", n, tt.stays)
+			}
+		})
+	}
+}
@@ -1 +1,11 @@
 package server
+
+import "sync/atomic"
+
+// Server statistics
+type Stats struct {
+	RefusedTooLarge  atomic.Uint64 // inbound packets exceeding the maximum packet size of server
+	DroppedTooLarge  atomic.Uint64 // outbound messages exceeding the maximum packet size of client
+	DroppedExpired   atomic.Uint64 // messages expired before delivery
+	KeepAliveTimeout atomic.Uint64 // connections closed for no packet within 1.5 times keep alive
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	. "github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/pkg/packets"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Subscribe topic filter with subscription identifier
+func testSubscribeID(t *testing.T, tc *testConn, filter string, subid uint32) *packets.Suback {
+	t.Helper()
+	props := &packets.Properties{}
+	if subid > 0 {
+		props.SubscriptionIdentifier = []uint32{subid}
+	}
+	tc.send(t, &packets.Subscribe{
+		FixHeader:     &packets.FixHeader{PacketType: packets.SUBSCRIBE, Qos: packets.Qos1},
+		Version:       packets.V5,
+		PacketID:      1,
+		Subscriptions: []packets.Subscription{{Topic: filter}},
+		Properties:    props,
+	})
+	ack, ok := tc.recv(t).(*packets.Suback)
+	if !ok {
+		t.Fatal("subscribe: want suback")
+	}
+	return ack
+}
+
+func TestNoLocal(t *testing.T) {
+	s := testServer(t)
+	tc, _ := testConnect(t, s, "c")
+	tc.subscribe(t, packets.Subscription{NoLocal: true}, "a/b")
+	tc.subscribe(t, packets.Subscription{}, "a/+")
+	other, _ := testConnect(t, s, "other")
+	other.subscribe(t, packets.Subscription{NoLocal: true}, "a/b")
+
+	// only the subscription without no local of its own client matches
+	tc.publish(t, "a/b", packets.Qos0, 0, "x")
+	if pp, ok := tc.recv(t).(*packets.Publish); !ok || string(pp.Payload) != "x" {
+		t.Fatalf("got %+v, want publish x", pp)
+	}
+	tc.none(t)
+	if pp, ok := other.recv(t).(*packets.Publish); !ok || string(pp.Payload) != "x" {
+		t.Fatalf("got %+v, want publish x", pp)
+	}
+}
+
+func TestNoLocalShared(t *testing.T) {
+	s := testServer(t)
+	tc, _ := testConnect(t, s, "c")
+	tc.send(t, &packets.Subscribe{
+		FixHeader:     &packets.FixHeader{PacketType: packets.SUBSCRIBE, Qos: packets.Qos1},
+		Version:       packets.V5,
+		PacketID:      1,
+		Subscriptions: []packets.Subscription{{Topic: "$share/g/a/b", NoLocal: true}},
+		Properties:    &packets.Properties{},
+	})
+	if pd, ok := tc.recv(t).(*packets.Disconnect); !ok || pd.ReasonCode != packets.ProtocolError {
+		t.Fatalf("got %+v, want disconnect %#x", pd, packets.ProtocolError)
+	}
+	tc.wait(t)
+}
+
+func TestSubscriptionIdentifier(t *testing.T) {
+	s := testServer(t)
+	tc, _ := testConnect(t, s, "c")
+	testSubscribeID(t, tc, "a/+", 1)
+	testSubscribeID(t, tc, "a/b", 2)
+	testSubscribeID(t, tc, "a/#", 0)
+	pub, _ := testConnect(t, s, "pub")
+	tests := []struct {
+		topic string
+		want  []uint32
+	}{
+		{"a/b", []uint32{1, 2}},
+		{"a/c", []uint32{1}},
+		{"a/c/d", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			pub.publish(t, tt.topic, packets.Qos0, 0, "x")
+			pp, ok := tc.recv(t).(*packets.Publish)
+			if !ok {
+				t.Fatal("want publish")
+			}
+			var got []uint32
+			if pp.Properties != nil && len(pp.Properties.SubscriptionIdentifier) > 0 {
+				got = append(got, pp.Properties.SubscriptionIdentifier...)
+				sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			tc.none(t)
+		})
+	}
+}
+
+func TestSubscriptionIdentifierNotSupported(t *testing.T) {
+	subID := Cfg.Mqtt.SubID
+	defer func() { Cfg.Mqtt.SubID = subID }()
+	Cfg.Mqtt.SubID = false
+	s := testServer(t)
+	_, ack := testConnect(t, s, "c")
+	if sa := ack.Properties.SubIDAvailable; sa == nil || *sa != 0 {
+		t.Fatalf("got subscription identifier available %v, want 0", sa)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	s := testServer(t)
+	s.acl = []*aclRule{{allow: false, action: AclSubscribe, topics: []string{"deny/#"}}}
+	tc, _ := testConnect(t, s, "c")
+	tc.subscribe(t, packets.Subscription{}, "a/b", "$share/g/c")
+	filters := []string{"a/b", "a/x", "a/#/b", "$share//c", "$share/g/c", "$share/g/c", "deny/x"}
+	tc.send(t, &packets.Unsubscribe{
+		FixHeader:  &packets.FixHeader{PacketType: packets.UNSUBSCRIBE, Qos: packets.Qos1},
+		Version:    packets.V5,
+		PacketID:   2,
+		Topics:     filters,
+		Properties: &packets.Properties{},
+	})
+	ack, ok := tc.recv(t).(*packets.Unsuback)
+	if !ok || ack.PacketID != 2 {
+		t.Fatalf("got %+v, want unsuback of packet id 2", ack)
+	}
+	want := []byte{
+		packets.Success,
+		packets.NoSubscriptionExisted,
+		packets.TopicFilterInvalid,
+		packets.TopicFilterInvalid,
+		packets.Success,
+		packets.NoSubscriptionExisted,
+		packets.NotAuthorized,
+	}
+	if !reflect.DeepEqual(ack.Payload, want) {
+		t.Fatalf("got %v, want %v", ack.Payload, want)
+	}
+
+	// messages of the unsubscribed filters are not delivered any more
+	pub, _ := testConnect(t, s, "pub")
+	pub.publish(t, "a/b", packets.Qos0, 0, "x")
+	pub.publish(t, "c", packets.Qos0, 0, "x")
+	tc.none(t)
+	for _, filter := range []string{"a/b", "$share/g/c"} {
+		if s.topicStore.Subscribed(filter) {
+			t.Fatalf("got %s subscribed, want unsubscribed", filter)
+		}
+	}
+}
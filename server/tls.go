@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/pkg/packets"
+	"github.com/pires/go-proxyproto"
+	"golang.org/x/net/websocket"
+	"os"
+)
+
+// Peer certificate identity
+const (
+	CertCN          = "cn"
+	CertSAN         = "san"
+	CertFingerprint = "fingerprint"
+)
+
+// TLS config of listener, client certificates are verified against ca cert and crl when configured
+func tlsConfig(name string) (*tls.Config, error) {
+	lc := config.Cfg.Listeners[name]
+	cert, err := tls.LoadX509KeyPair(lc.TLSCert, lc.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	if lc.CACert == "" {
+		if lc.VerifyPeer {
+			return nil, errors.New("verify peer without ca cert")
+		}
+		return cfg, nil
+	}
+
+	bs, err := os.ReadFile(lc.CACert)
+	if err != nil {
+		return nil, err
+	}
+	cas := make([]*x509.Certificate, 0)
+	for block, rest := pem.Decode(bs); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		cas = append(cas, ca)
+	}
+	if len(cas) == 0 {
+		return nil, fmt.Errorf("no certificate in %s", lc.CACert)
+	}
+	pool := x509.NewCertPool()
+	for _, ca := range cas {
+		pool.AddCert(ca)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	if lc.VerifyPeer {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	if lc.CRL != "" {
+		crls, err := loadCRL(lc.CRL, cas)
+		if err != nil {
+			return nil, err
+		}
+		cfg.VerifyPeerCertificate = verifyCRL(crls)
+	}
+	return cfg, nil
+}
+
+// Load crl file in pem or der, the crl must be signed by one of the ca certs
+func loadCRL(path string, cas []*x509.Certificate) ([]*x509.RevocationList, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ders := make([][]byte, 0)
+	for block, rest := pem.Decode(bs); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "X509 CRL" {
+			ders = append(ders, block.Bytes)
+		}
+	}
+	if len(ders) == 0 {
+		ders = append(ders, bs)
+	}
+	crls := make([]*x509.RevocationList, 0, len(ders))
+	for _, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return nil, err
+		}
+		signed := false
+		for _, ca := range cas {
+			if crl.CheckSignatureFrom(ca) == nil {
+				signed = true
+				break
+			}
+		}
+		if !signed {
+			return nil, fmt.Errorf("crl of %s not signed by ca cert", crl.Issuer)
+		}
+		crls = append(crls, crl)
+	}
+	return crls, nil
+}
+
+// Reject certificates revoked by crl
+func verifyCRL(crls []*x509.RevocationList) func([][]byte, [][]*x509.Certificate) error {
+	return func(_ [][]byte, chains [][]*x509.Certificate) error {
+		for _, chain := range chains {
+			for _, cert := range chain {
+				for _, crl := range crls {
+					if !bytes.Equal(crl.RawIssuer, cert.RawIssuer) {
+						continue
+					}
+					for _, rc := range crl.RevokedCertificateEntries {
+						if rc.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+							return fmt.Errorf("certificate %s revoked", cert.Subject)
+						}
+					}
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// Peer certificate of client, nil when client has no certificate
+func (c *Client) PeerCertificate() *x509.Certificate {
+	conn := c.conn
+	if pc, ok := conn.(*proxyproto.Conn); ok {
+		conn = pc.Raw()
+	}
+	var state *tls.ConnectionState
+	switch tc := conn.(type) {
+	case *tls.Conn:
+		cs := tc.ConnectionState()
+		state = &cs
+	case *websocket.Conn:
+		if req := tc.Request(); req != nil {
+			state = req.TLS
+		}
+	}
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
+}
+
+// Take identity of peer certificate as username or client id
+func (c *Client) peerIdentity(pc *packets.Connect) {
+	lc, ok := config.Cfg.Listeners[c.listener]
+	if !ok || lc.CertAsUsername == "" && lc.CertAsClientID == "" {
+		return
+	}
+	cert := c.PeerCertificate()
+	if cert == nil {
+		return
+	}
+	if id := certIdentity(cert, lc.CertAsUsername); id != "" {
+		pc.Username = id
+	}
+	if id := certIdentity(cert, lc.CertAsClientID); id != "" {
+		pc.ClientID = id
+	}
+}
+
+// Identity of certificate, san is the first of dns names, uris, emails and ips
+func certIdentity(cert *x509.Certificate, kind string) string {
+	switch kind {
+	case CertCN:
+		return cert.Subject.CommonName
+	case CertSAN:
+		switch {
+		case len(cert.DNSNames) > 0:
+			return cert.DNSNames[0]
+		case len(cert.URIs) > 0:
+			return cert.URIs[0].String()
+		case len(cert.EmailAddresses) > 0:
+			return cert.EmailAddresses[0]
+		case len(cert.IPAddresses) > 0:
+			return cert.IPAddresses[0].String()
+		}
+	case CertFingerprint:
+		sum := sha256.Sum256(cert.Raw)
+		return hex.EncodeToString(sum[:])
+	}
+	return ""
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	. "github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/pkg/packets"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Certificate signed by parent, a nil parent makes a self signed ca
+func testCert(t *testing.T, serial int64, cn string, dns []string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dns,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, any(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// Write pem blocks into file of dir
+func testPem(t *testing.T, dir, name string, blocks ...*pem.Block) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	var bs []byte
+	for _, block := range blocks {
+		bs = append(bs, pem.EncodeToMemory(block)...)
+	}
+	if err := os.WriteFile(path, bs, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Listener tls with ca, server cert and crl revoking serial 3
+func testTLS(t *testing.T, verifyPeer bool) (ca tls.Certificate, valid, revoked tls.Certificate) {
+	t.Helper()
+	lc := Cfg.Listeners["tls"]
+	saved := *lc
+	t.Cleanup(func() { *lc = saved })
+
+	dir := t.TempDir()
+	ca = testCert(t, 1, "ca", nil, nil)
+	server := testCert(t, 2, "server", []string{"localhost"}, &ca)
+	revoked = testCert(t, 3, "revoked", nil, &ca)
+	valid = testCert(t, 4, "device", []string{"device.example.com"}, &ca)
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                time.Now(),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{{SerialNumber: big.NewInt(3), RevocationTime: time.Now()}},
+	}, ca.Leaf, ca.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := x509.MarshalECPrivateKey(server.PrivateKey.(*ecdsa.PrivateKey))
+	lc.CACert = testPem(t, dir, "ca.pem", &pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]})
+	lc.TLSCert = testPem(t, dir, "server.pem", &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate[0]})
+	lc.TLSKey = testPem(t, dir, "server.key", &pem.Block{Type: "EC PRIVATE KEY", Bytes: key})
+	lc.CRL = testPem(t, dir, "crl.pem", &pem.Block{Type: "X509 CRL", Bytes: crl})
+	lc.VerifyPeer = verifyPeer
+	return ca, valid, revoked
+}
+
+// Handshake of client with certs over loopback, returns the server side connection
+func testHandshake(t *testing.T, ca tls.Certificate, certs ...tls.Certificate) (*tls.Conn, error) {
+	t.Helper()
+	cfg, err := tlsConfig("tls")
+	if err != nil {
+		t.Fatalf("tls config: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	dialed := make(chan *tls.Conn, 1)
+	go func() {
+		conn, _ := tls.Dial("tcp", ln.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: certs})
+		dialed <- conn
+	}()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := tls.Server(conn, cfg)
+	err = sc.Handshake()
+	if cc := <-dialed; cc != nil {
+		_ = cc.Close()
+	}
+	t.Cleanup(func() { _ = sc.Close() })
+	return sc, err
+}
+
+func TestTLSClientCert(t *testing.T) {
+	tests := []struct {
+		name       string
+		verifyPeer bool
+		cert       string
+		ok         bool
+	}{
+		{"valid", true, "valid", true},
+		{"revoked", true, "revoked", false},
+		{"missing", true, "", false},
+		{"optional missing", false, "", true},
+		{"optional revoked", false, "revoked", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca, valid, revoked := testTLS(t, tt.verifyPeer)
+			var certs []tls.Certificate
+			switch tt.cert {
+			case "valid":
+				certs = append(certs, valid)
+			case "revoked":
+				certs = append(certs, revoked)
+			}
+			if _, err := testHandshake(t, ca, certs...); (err == nil) != tt.ok {
+				t.Fatalf("got %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLoadCRLNotSigned(t *testing.T) {
+	testTLS(t, true)
+	other := testCert(t, 1, "other", nil, nil)
+	if _, err := loadCRL(Cfg.Listeners["tls"].CRL, []*x509.Certificate{other.Leaf}); err == nil {
+		t.Fatal("got nil, want error of crl not signed by ca")
+	}
+}
+
+func TestPeerIdentity(t *testing.T) {
+	ca, valid, _ := testTLS(t, true)
+	sum := sha256.Sum256(valid.Certificate[0])
+	tests := []struct {
+		name     string
+		username string
+		clientID string
+		want     [2]string
+	}{
+		{"none", "", "", [2]string{"u", "c"}},
+		{"cn as username", CertCN, "", [2]string{"device", "c"}},
+		{"san as client id", "", CertSAN, [2]string{"u", "device.example.com"}},
+		{"fingerprint", CertFingerprint, CertCN, [2]string{hex.EncodeToString(sum[:]), "device"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lc := Cfg.Listeners["tls"]
+			lc.CertAsUsername, lc.CertAsClientID = tt.username, tt.clientID
+			conn, err := testHandshake(t, ca, valid)
+			if err != nil {
+				t.Fatalf("handshake: %v", err)
+			}
+			c := &Client{conn: conn, listener: "tls"}
+			pc := &packets.Connect{Username: "u", ClientID: "c"}
+			c.peerIdentity(pc)
+			if got := [2]string{pc.Username, pc.ClientID}; got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
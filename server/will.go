@@ -0,0 +1,74 @@
+package server
+
+import (
+	. "github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/log"
+	"github.com/laomar/gomq/pkg/packets"
+	"strings"
+	"unicode/utf8"
+)
+
+// Check will flags of connect
+func checkWill(pc *packets.Connect) byte {
+	if !pc.WillFlag {
+		return packets.Success
+	}
+	if pc.WillTopic == "" || strings.ContainsAny(pc.WillTopic, "+#") {
+		return packets.TopicNameInvalid
+	}
+	if pc.WillQos > Cfg.Mqtt.MaximumQoS {
+		return packets.QoSNotSupported
+	}
+	if pc.WillRetain && !Cfg.Mqtt.RetainAvailable {
+		return packets.RetainNotSupported
+	}
+	if wp := pc.WillProperties; wp != nil && wp.PayloadFormat != nil && *wp.PayloadFormat == 1 && !utf8.ValidString(pc.WillMsg) {
+		return packets.PayloadFormatInvalid
+	}
+	return packets.Success
+}
+
+// Create will message from connect
+func newWill(pc *packets.Connect) *packets.Publish {
+	if !pc.WillFlag {
+		return nil
+	}
+	will := &packets.Publish{
+		FixHeader: &packets.FixHeader{
+			PacketType: packets.PUBLISH,
+			Qos:        pc.WillQos,
+			Retain:     pc.WillRetain,
+		},
+		TopicName: pc.WillTopic,
+		Payload:   []byte(pc.WillMsg),
+	}
+	if wp := pc.WillProperties; wp != nil {
+		will.Properties = &packets.Properties{
+			PayloadFormat:   wp.PayloadFormat,
+			MessageExpiry:   wp.MessageExpiry,
+			ContentType:     wp.ContentType,
+			ResponseTopic:   wp.ResponseTopic,
+			CorrelationData: wp.CorrelationData,
+			User:            wp.User,
+		}
+	}
+	return will
+}
+
+// Publish will message
+func (s *Server) sendWill(cid string, will *packets.Publish) {
+	if will == nil {
+		return
+	}
+	log.Debugf("will: publish topic=%s", will.TopicName)
+	s.publish(cid, will)
+}
+
+// Publish the delayed will message of session
+func (s *Server) delayWill(ss *session) {
+	ss.Lock()
+	will := ss.will
+	ss.will = nil
+	ss.Unlock()
+	s.sendWill(ss.id, will)
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	. "github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/pkg/packets"
+	"testing"
+	"time"
+)
+
+// Connect option of will message on topic will, delay is the will delay interval
+func withWill(delay uint32) func(*packets.Connect) {
+	return func(pc *packets.Connect) {
+		pc.WillFlag = true
+		pc.WillTopic = "will"
+		pc.WillMsg = "gone"
+		pc.WillProperties = &packets.Properties{}
+		if delay > 0 {
+			pc.WillProperties.WillDelayInterval = &delay
+		}
+	}
+}
+
+func TestWill(t *testing.T) {
+	tests := []struct {
+		name  string
+		close func(t *testing.T, tc *testConn)
+		want  bool
+	}{
+		{"abnormal close", func(t *testing.T, tc *testConn) { _ = tc.Close() }, true},
+		{"normal disconnect", func(t *testing.T, tc *testConn) {
+			tc.send(t, &packets.Disconnect{Version: packets.V5, ReasonCode: packets.Success})
+		}, false},
+		{"disconnect with will", func(t *testing.T, tc *testConn) {
+			tc.send(t, &packets.Disconnect{Version: packets.V5, ReasonCode: packets.DisconnectWithWillMessage})
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			sub, _ := testConnect(t, s, "sub")
+			sub.subscribe(t, packets.Subscription{}, "will")
+			tc, _ := testConnect(t, s, "c", withWill(0))
+			tt.close(t, tc)
+			tc.wait(t)
+			if !tt.want {
+				sub.none(t)
+				return
+			}
+			if pp, ok := sub.recv(t).(*packets.Publish); !ok || string(pp.Payload) != "gone" {
+				t.Fatalf("got %+v, want will", pp)
+			}
+		})
+	}
+}
+
+func TestWillDelay(t *testing.T) {
+	tests := []struct {
+		name      string
+		delay     uint32
+		expiry    uint32
+		reconnect bool
+		want      bool
+	}{
+		{"delayed", 1, 60, false, true},
+		{"session end first", 60, 1, false, true},
+		{"cancelled by reconnect", 1, 60, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			sub, _ := testConnect(t, s, "sub")
+			sub.subscribe(t, packets.Subscription{}, "will")
+			tc, _ := testConnect(t, s, "c", withWill(tt.delay), withExpiry(tt.expiry))
+			_ = tc.Close()
+			testOffline(t, s, "c")
+			sub.none(t)
+			if tt.reconnect {
+				testConnect(t, s, "c", withExpiry(tt.expiry), resume)
+			}
+			time.Sleep(time.Second)
+			if !tt.want {
+				sub.none(t)
+				return
+			}
+			if pp, ok := sub.recv(t).(*packets.Publish); !ok || string(pp.Payload) != "gone" {
+				t.Fatalf("got %+v, want will", pp)
+			}
+		})
+	}
+}
+
+func TestCheckWill(t *testing.T) {
+	maxQos := Cfg.Mqtt.MaximumQoS
+	defer func() { Cfg.Mqtt.MaximumQoS = maxQos }()
+	Cfg.Mqtt.MaximumQoS = packets.Qos1
+	format := byte(1)
+	tests := []struct {
+		name string
+		pc   *packets.Connect
+		want byte
+	}{
+		{"no will", &packets.Connect{}, packets.Success},
+		{"will", &packets.Connect{WillFlag: true, WillTopic: "a/b"}, packets.Success},
+		{"empty topic", &packets.Connect{WillFlag: true}, packets.TopicNameInvalid},
+		{"wildcard topic", &packets.Connect{WillFlag: true, WillTopic: "a/+"}, packets.TopicNameInvalid},
+		{"qos", &packets.Connect{WillFlag: true, WillTopic: "a/b", WillQos: packets.Qos2}, packets.QoSNotSupported},
+		{"payload format", &packets.Connect{WillFlag: true, WillTopic: "a/b", WillMsg: "\xff",
+			WillProperties: &packets.Properties{PayloadFormat: &format}}, packets.PayloadFormatInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkWill(tt.pc); got != tt.want {
+				t.Fatalf("got %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
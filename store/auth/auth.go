@@ -0,0 +1,16 @@
+package auth
+
+const prefix = "auth:"
+
+// Credential of user, the password is a bcrypt or sha256 hash or a scram verifier
+type Credential struct {
+	Username string
+	Password string
+}
+
+type Store interface {
+	Get(string) (*Credential, error)
+	Set(*Credential) error
+	Del(string) error
+	Close() error
+}
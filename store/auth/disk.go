@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"encoding/json"
+	"github.com/laomar/gomq/config"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+type disk struct {
+	db *leveldb.DB
+}
+
+func NewDisk() (*disk, error) {
+	db, err := leveldb.OpenFile(config.Cfg.DataDir+"/auth", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &disk{
+		db: db,
+	}, nil
+}
+
+// Get credential of user, returns nil when not found
+func (d *disk) Get(username string) (*Credential, error) {
+	val, err := d.db.Get([]byte(prefix+username), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := new(Credential)
+	return c, json.Unmarshal(val, c)
+}
+
+func (d *disk) Set(c *Credential) error {
+	js, _ := json.Marshal(c)
+	return d.db.Put([]byte(prefix+c.Username), js, nil)
+}
+
+func (d *disk) Del(username string) error {
+	return d.db.Delete([]byte(prefix+username), nil)
+}
+
+func (d *disk) Close() error {
+	return d.db.Close()
+}
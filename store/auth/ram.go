@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"sync"
+)
+
+type Ram struct {
+	sync.RWMutex
+	credentials map[string]*Credential
+}
+
+func NewRam() *Ram {
+	return &Ram{
+		credentials: make(map[string]*Credential),
+	}
+}
+
+// Get credential of user, returns nil when not found
+func (r *Ram) Get(username string) (*Credential, error) {
+	defer r.RUnlock()
+	r.RLock()
+	return r.credentials[username], nil
+}
+
+func (r *Ram) Set(c *Credential) error {
+	defer r.Unlock()
+	r.Lock()
+	r.credentials[c.Username] = c
+	return nil
+}
+
+func (r *Ram) Del(username string) error {
+	defer r.Unlock()
+	r.Lock()
+	delete(r.credentials, username)
+	return nil
+}
+
+func (r *Ram) Close() error {
+	return nil
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// Credentials are shared by all nodes of the cluster
+type redis struct {
+	db  goredis.UniversalClient
+	key string
+}
+
+func NewRedis(db goredis.UniversalClient) *redis {
+	return &redis{
+		db:  db,
+		key: prefix,
+	}
+}
+
+// Get credential of user, returns nil when not found
+func (r *redis) Get(username string) (*Credential, error) {
+	val, err := r.db.HGet(context.Background(), r.key, username).Result()
+	if err == goredis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := new(Credential)
+	return c, json.Unmarshal([]byte(val), c)
+}
+
+func (r *redis) Set(c *Credential) error {
+	js, _ := json.Marshal(c)
+	_, err := r.db.HSet(context.Background(), r.key, c.Username, string(js)).Result()
+	return err
+}
+
+func (r *redis) Del(username string) error {
+	_, err := r.db.HDel(context.Background(), r.key, username).Result()
+	return err
+}
+
+func (r *redis) Close() error {
+	return r.db.Close()
+}
@@ -1,6 +1,13 @@
 package store
 
-import "github.com/laomar/gomq/store/topic"
+import (
+	"github.com/laomar/gomq/store/auth"
+	"github.com/laomar/gomq/store/inflight"
+	"github.com/laomar/gomq/store/queue"
+	"github.com/laomar/gomq/store/retain"
+	"github.com/laomar/gomq/store/session"
+	"github.com/laomar/gomq/store/topic"
+)
 
 type disk struct {
 }
@@ -12,3 +19,23 @@ func NewDisk() Store {
 func (d *disk) NewTopicStore() (topic.Store, error) {
 	return topic.NewDisk()
 }
+
+func (d *disk) NewRetainStore() (retain.Store, error) {
+	return retain.NewDisk()
+}
+
+func (d *disk) NewSessionStore() (session.Store, error) {
+	return session.NewDisk()
+}
+
+func (d *disk) NewQueueStore() (queue.Store, error) {
+	return queue.NewDisk()
+}
+
+func (d *disk) NewInflightStore() (inflight.Store, error) {
+	return inflight.NewDisk()
+}
+
+func (d *disk) NewAuthStore() (auth.Store, error) {
+	return auth.NewDisk()
+}
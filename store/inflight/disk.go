@@ -0,0 +1,63 @@
+package inflight
+
+import (
+	"encoding/json"
+	"github.com/laomar/gomq/config"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+type disk struct {
+	db *leveldb.DB
+}
+
+func NewDisk() (*disk, error) {
+	db, err := leveldb.OpenFile(config.Cfg.DataDir+"/inflight", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &disk{
+		db: db,
+	}, nil
+}
+
+func key(cid string, inbound bool, id uint16) []byte {
+	return []byte(prefix + cid + ":" + field(inbound, id))
+}
+
+func (d *disk) Set(cid string, m *Message) error {
+	js, _ := json.Marshal(m)
+	return d.db.Put(key(cid, m.Inbound, m.PacketID), js, nil)
+}
+
+func (d *disk) Del(cid string, inbound bool, id uint16) error {
+	return d.db.Delete(key(cid, inbound, id), nil)
+}
+
+func (d *disk) All(cid string) ([]*Message, error) {
+	iter := d.db.NewIterator(util.BytesPrefix([]byte(prefix+cid+":")), nil)
+	defer iter.Release()
+	ms := make([]*Message, 0)
+	for iter.Next() {
+		m := new(Message)
+		if err := json.Unmarshal(iter.Value(), m); err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	return ms, iter.Error()
+}
+
+func (d *disk) Clear(cid string) error {
+	iter := d.db.NewIterator(util.BytesPrefix([]byte(prefix+cid+":")), nil)
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		batch.Delete(iter.Key())
+	}
+	iter.Release()
+	return d.db.Write(batch, nil)
+}
+
+func (d *disk) Close() error {
+	return d.db.Close()
+}
@@ -0,0 +1,36 @@
+package inflight
+
+import (
+	"github.com/laomar/gomq/pkg/packets"
+	"strconv"
+)
+
+const prefix = "inflight:"
+
+// Message of session waiting for acknowledgement, outbound qos 1 and 2 messages
+// and inbound qos 2 messages waiting for pubrel, expire at is unix milliseconds, 0 means never
+type Message struct {
+	PacketID uint16
+	Inbound  bool
+	State    byte
+	Seq      uint64
+	Share    string
+	Publish  *packets.Publish
+	ExpireAt int64
+}
+
+// Field of message, packet ids of inbound and outbound messages are apart
+func field(inbound bool, id uint16) string {
+	if inbound {
+		return "i" + strconv.Itoa(int(id))
+	}
+	return "o" + strconv.Itoa(int(id))
+}
+
+type Store interface {
+	Set(string, *Message) error
+	Del(string, bool, uint16) error
+	All(string) ([]*Message, error)
+	Clear(string) error
+	Close() error
+}
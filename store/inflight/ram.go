@@ -0,0 +1,61 @@
+package inflight
+
+import (
+	"sync"
+)
+
+type Ram struct {
+	sync.RWMutex
+	msgs map[string]map[string]*Message
+}
+
+func NewRam() *Ram {
+	return &Ram{
+		msgs: make(map[string]map[string]*Message),
+	}
+}
+
+func (r *Ram) Set(cid string, m *Message) error {
+	defer r.Unlock()
+	r.Lock()
+	ms, ok := r.msgs[cid]
+	if !ok {
+		ms = make(map[string]*Message)
+		r.msgs[cid] = ms
+	}
+	ms[field(m.Inbound, m.PacketID)] = m
+	return nil
+}
+
+func (r *Ram) Del(cid string, inbound bool, id uint16) error {
+	defer r.Unlock()
+	r.Lock()
+	if ms, ok := r.msgs[cid]; ok {
+		delete(ms, field(inbound, id))
+		if len(ms) == 0 {
+			delete(r.msgs, cid)
+		}
+	}
+	return nil
+}
+
+func (r *Ram) All(cid string) ([]*Message, error) {
+	defer r.RUnlock()
+	r.RLock()
+	ms := make([]*Message, 0, len(r.msgs[cid]))
+	for _, m := range r.msgs[cid] {
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+func (r *Ram) Clear(cid string) error {
+	defer r.Unlock()
+	r.Lock()
+	delete(r.msgs, cid)
+	return nil
+}
+
+func (r *Ram) Close() error {
+	return nil
+}
@@ -0,0 +1,61 @@
+package inflight
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/laomar/gomq/config"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+type redis struct {
+	db     goredis.UniversalClient
+	prefix string
+}
+
+func NewRedis(db goredis.UniversalClient) *redis {
+	redis := &redis{
+		db:     db,
+		prefix: prefix,
+	}
+	nodeName := config.Cfg.NodeName
+	if nodeName != "" {
+		redis.prefix += nodeName + ":"
+	}
+	return redis
+}
+
+func (r *redis) Set(cid string, m *Message) error {
+	js, _ := json.Marshal(m)
+	_, err := r.db.HSet(context.Background(), r.prefix+cid, field(m.Inbound, m.PacketID), string(js)).Result()
+	return err
+}
+
+func (r *redis) Del(cid string, inbound bool, id uint16) error {
+	_, err := r.db.HDel(context.Background(), r.prefix+cid, field(inbound, id)).Result()
+	return err
+}
+
+func (r *redis) All(cid string) ([]*Message, error) {
+	vals, err := r.db.HGetAll(context.Background(), r.prefix+cid).Result()
+	if err != nil {
+		return nil, err
+	}
+	ms := make([]*Message, 0, len(vals))
+	for _, v := range vals {
+		m := new(Message)
+		if err := json.Unmarshal([]byte(v), m); err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+func (r *redis) Clear(cid string) error {
+	_, err := r.db.Del(context.Background(), r.prefix+cid).Result()
+	return err
+}
+
+func (r *redis) Close() error {
+	return r.db.Close()
+}
@@ -0,0 +1,92 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/laomar/gomq/config"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+type disk struct {
+	ram *Ram
+	db  *leveldb.DB
+}
+
+func NewDisk() (*disk, error) {
+	db, err := leveldb.OpenFile(config.Cfg.DataDir+"/queue", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &disk{
+		db:  db,
+		ram: NewRam(),
+	}, nil
+}
+
+func key(cid string, seq uint64) []byte {
+	return []byte(fmt.Sprintf("%s%s:%016x", prefix, cid, seq))
+}
+
+func (d *disk) Init(cids ...string) error {
+	for _, cid := range cids {
+		iter := d.db.NewIterator(util.BytesPrefix([]byte(prefix+cid+":")), nil)
+		for iter.Next() {
+			m := new(Message)
+			if err := json.Unmarshal(iter.Value(), m); err != nil {
+				iter.Release()
+				return err
+			}
+			_, _ = d.ram.push(cid, m)
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *disk) Push(cid string, m *Message) error {
+	dropped, err := d.ram.push(cid, m)
+	batch := new(leveldb.Batch)
+	for _, dm := range dropped {
+		batch.Delete(key(cid, dm.Seq))
+	}
+	if err == nil {
+		jm, _ := json.Marshal(m)
+		batch.Put(key(cid, m.Seq), jm)
+	}
+	if werr := d.db.Write(batch, nil); werr != nil {
+		return werr
+	}
+	return err
+}
+
+func (d *disk) Pop(cid string, n int) ([]*Message, error) {
+	ms := d.ram.pop(cid, n)
+	batch := new(leveldb.Batch)
+	for _, m := range ms {
+		batch.Delete(key(cid, m.Seq))
+	}
+	return ms, d.db.Write(batch, nil)
+}
+
+func (d *disk) Len(cid string) int {
+	return d.ram.Len(cid)
+}
+
+func (d *disk) Clear(cid string) error {
+	d.ram.clear(cid)
+	iter := d.db.NewIterator(util.BytesPrefix([]byte(prefix+cid+":")), nil)
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		batch.Delete(iter.Key())
+	}
+	iter.Release()
+	return d.db.Write(batch, nil)
+}
+
+func (d *disk) Close() error {
+	return d.db.Close()
+}
@@ -0,0 +1,39 @@
+package queue
+
+import (
+	"errors"
+	"github.com/laomar/gomq/pkg/packets"
+)
+
+const prefix = "queue:"
+
+// Drop policy when the queue is full
+const (
+	DropOldest = "oldest"
+	DropNewest = "newest"
+	DropQos0   = "qos0"
+	// drop the newest message and disconnect the connected client with quota exceeded
+	DropDisconnect = "disconnect"
+)
+
+var ErrDropped = errors.New("queue is full, message dropped")
+
+// Queued message, expire at is unix milliseconds the message expires at, 0 means never
+type Message struct {
+	Seq      uint64
+	Publish  *packets.Publish
+	ExpireAt int64
+}
+
+func (m *Message) size() int {
+	return len(m.Publish.TopicName) + len(m.Publish.Payload)
+}
+
+type Store interface {
+	Init(...string) error
+	Push(string, *Message) error
+	Pop(string, int) ([]*Message, error)
+	Len(string) int
+	Clear(string) error
+	Close() error
+}
@@ -0,0 +1,146 @@
+package queue
+
+import (
+	"container/list"
+	"github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/pkg/packets"
+	"sync"
+)
+
+type queue struct {
+	msgs *list.List
+	size int
+}
+
+type Ram struct {
+	sync.Mutex
+	seq     uint64
+	maxLen  int
+	maxSize int
+	policy  string
+	queues  map[string]*queue
+}
+
+func NewRam() *Ram {
+	return &Ram{
+		maxLen:  config.Cfg.Mqtt.MaxQueueLen,
+		maxSize: config.Cfg.Mqtt.MaxQueueSize,
+		policy:  config.Cfg.Mqtt.QueueDropPolicy,
+		queues:  make(map[string]*queue),
+	}
+}
+
+func (r *Ram) Init(cids ...string) error {
+	return nil
+}
+
+func (r *Ram) full(q *queue, m *Message) bool {
+	return (r.maxLen > 0 && q.msgs.Len()+1 > r.maxLen) || (r.maxSize > 0 && q.size+m.size() > r.maxSize)
+}
+
+func (r *Ram) remove(q *queue, e *list.Element) *Message {
+	m := q.msgs.Remove(e).(*Message)
+	q.size -= m.size()
+	return m
+}
+
+// Push message to the tail of queue, returns the messages dropped by the policy
+func (r *Ram) push(cid string, m *Message) ([]*Message, error) {
+	defer r.Unlock()
+	r.Lock()
+	if m.Seq == 0 {
+		r.seq++
+		m.Seq = r.seq
+	} else if m.Seq > r.seq {
+		r.seq = m.Seq
+	}
+	if r.maxSize > 0 && m.size() > r.maxSize {
+		return nil, ErrDropped
+	}
+	q, ok := r.queues[cid]
+	if !ok {
+		q = &queue{msgs: list.New()}
+		r.queues[cid] = q
+	}
+	dropped := make([]*Message, 0)
+	for r.full(q, m) {
+		e := q.msgs.Front()
+		switch r.policy {
+		case DropNewest, DropDisconnect:
+			return dropped, ErrDropped
+		case DropQos0:
+			for ; e != nil; e = e.Next() {
+				if e.Value.(*Message).Publish.FixHeader.Qos == packets.Qos0 {
+					break
+				}
+			}
+			if e == nil {
+				if m.Publish.FixHeader.Qos == packets.Qos0 {
+					return dropped, ErrDropped
+				}
+				e = q.msgs.Front()
+			}
+		}
+		dropped = append(dropped, r.remove(q, e))
+	}
+	q.msgs.PushBack(m)
+	q.size += m.size()
+	return dropped, nil
+}
+
+func (r *Ram) pop(cid string, n int) []*Message {
+	defer r.Unlock()
+	r.Lock()
+	ms := make([]*Message, 0)
+	q, ok := r.queues[cid]
+	if !ok {
+		return ms
+	}
+	for i := 0; i < n && q.msgs.Len() > 0; i++ {
+		ms = append(ms, r.remove(q, q.msgs.Front()))
+	}
+	if q.msgs.Len() == 0 {
+		delete(r.queues, cid)
+	}
+	return ms
+}
+
+func (r *Ram) clear(cid string) []*Message {
+	defer r.Unlock()
+	r.Lock()
+	ms := make([]*Message, 0)
+	if q, ok := r.queues[cid]; ok {
+		for e := q.msgs.Front(); e != nil; e = e.Next() {
+			ms = append(ms, e.Value.(*Message))
+		}
+		delete(r.queues, cid)
+	}
+	return ms
+}
+
+func (r *Ram) Push(cid string, m *Message) error {
+	_, err := r.push(cid, m)
+	return err
+}
+
+func (r *Ram) Pop(cid string, n int) ([]*Message, error) {
+	return r.pop(cid, n), nil
+}
+
+func (r *Ram) Len(cid string) int {
+	defer r.Unlock()
+	r.Lock()
+	if q, ok := r.queues[cid]; ok {
+		return q.msgs.Len()
+	}
+	return 0
+}
+
+func (r *Ram) Clear(cid string) error {
+	r.clear(cid)
+	return nil
+}
+
+func (r *Ram) Close() error {
+	return nil
+}
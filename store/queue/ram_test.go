@@ -0,0 +1,109 @@
+package queue
+
+import (
+	"github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/pkg/packets"
+	"testing"
+)
+
+// Message of qos with payload, topic a makes its size one more than the payload
+func testMessage(qos byte, payload string) *Message {
+	return &Message{
+		Publish: &packets.Publish{
+			FixHeader: &packets.FixHeader{PacketType: packets.PUBLISH, Qos: qos},
+			TopicName: "a",
+			Payload:   []byte(payload),
+		},
+	}
+}
+
+func payloads(ms []*Message) string {
+	s := ""
+	for _, m := range ms {
+		s += string(m.Publish.Payload)
+	}
+	return s
+}
+
+func TestDropPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		maxLen  int
+		maxSize int
+		qos     []byte
+		dropped int
+		want    string
+	}{
+		{"oldest", DropOldest, 3, 0, []byte{1, 1, 1, 1, 1}, 0, "cde"},
+		{"newest", DropNewest, 3, 0, []byte{1, 1, 1, 1, 1}, 2, "abc"},
+		{"disconnect", DropDisconnect, 3, 0, []byte{1, 1, 1, 1}, 1, "abc"},
+		{"qos0 first", DropQos0, 3, 0, []byte{1, 0, 1, 0, 1}, 0, "ace"},
+		{"qos0 oldest without qos0", DropQos0, 2, 0, []byte{1, 1, 1}, 0, "bc"},
+		{"qos0 newest of qos0", DropQos0, 2, 0, []byte{1, 1, 0}, 1, "ab"},
+		{"max size", DropOldest, 0, 4, []byte{1, 1, 1}, 0, "bc"},
+		{"unlimited", DropOldest, 0, 0, []byte{1, 1, 1, 1}, 0, "abcd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRam()
+			r.policy, r.maxLen, r.maxSize = tt.policy, tt.maxLen, tt.maxSize
+			dropped := 0
+			for i, qos := range tt.qos {
+				if err := r.Push("c", testMessage(qos, string(rune('a'+i)))); err == ErrDropped {
+					dropped++
+				} else if err != nil {
+					t.Fatalf("push: %v", err)
+				}
+			}
+			ms, _ := r.Pop("c", 10)
+			if got := payloads(ms); dropped != tt.dropped || got != tt.want {
+				t.Fatalf("got %q dropped %d, want %q dropped %d", got, dropped, tt.want, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestDropOversize(t *testing.T) {
+	r := NewRam()
+	r.maxSize = 4
+	if err := r.Push("c", testMessage(1, "abcd")); err != ErrDropped {
+		t.Fatalf("got %v, want %v", err, ErrDropped)
+	}
+	if n := r.Len("c"); n != 0 {
+		t.Fatalf("len %d, want 0", n)
+	}
+}
+
+func TestDiskInit(t *testing.T) {
+	dir := config.Cfg.DataDir
+	defer func() { config.Cfg.DataDir = dir }()
+	config.Cfg.DataDir = t.TempDir()
+	d, err := NewDisk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.ram.policy, d.ram.maxLen = DropOldest, 2
+	for _, payload := range []string{"a", "b", "c"} {
+		if err := d.Push("c", testMessage(1, payload)); err != nil {
+			t.Fatalf("push: %v", err)
+		}
+	}
+	if _, err := d.Pop("c", 1); err != nil {
+		t.Fatalf("pop: %v", err)
+	}
+	_ = d.Close()
+
+	// the dropped and popped messages are not restored
+	if d, err = NewDisk(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.Init("c"); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	ms, _ := d.Pop("c", 10)
+	if got := payloads(ms); got != "c" {
+		t.Fatalf("got %q, want %q", got, "c")
+	}
+}
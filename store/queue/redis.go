@@ -0,0 +1,97 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/laomar/gomq/config"
+	goredis "github.com/redis/go-redis/v9"
+	"sort"
+	"strconv"
+)
+
+type redis struct {
+	ram    *Ram
+	db     goredis.UniversalClient
+	prefix string
+}
+
+func NewRedis(db goredis.UniversalClient) *redis {
+	redis := &redis{
+		ram:    NewRam(),
+		db:     db,
+		prefix: prefix,
+	}
+	nodeName := config.Cfg.NodeName
+	if nodeName != "" {
+		redis.prefix += nodeName + ":"
+	}
+	return redis
+}
+
+func (r *redis) Init(cids ...string) error {
+	for _, cid := range cids {
+		vals, err := r.db.HGetAll(context.Background(), r.prefix+cid).Result()
+		if err != nil {
+			return err
+		}
+		ms := make([]*Message, 0, len(vals))
+		for _, v := range vals {
+			m := new(Message)
+			if err := json.Unmarshal([]byte(v), m); err != nil {
+				return err
+			}
+			ms = append(ms, m)
+		}
+		sort.Slice(ms, func(i, j int) bool {
+			return ms[i].Seq < ms[j].Seq
+		})
+		for _, m := range ms {
+			_, _ = r.ram.push(cid, m)
+		}
+	}
+	return nil
+}
+
+func (r *redis) Push(cid string, m *Message) error {
+	dropped, err := r.ram.push(cid, m)
+	ctx := context.Background()
+	pipe := r.db.Pipeline()
+	for _, dm := range dropped {
+		pipe.HDel(ctx, r.prefix+cid, strconv.FormatUint(dm.Seq, 10))
+	}
+	if err == nil {
+		jm, _ := json.Marshal(m)
+		pipe.HSet(ctx, r.prefix+cid, strconv.FormatUint(m.Seq, 10), string(jm))
+	}
+	if _, perr := pipe.Exec(ctx); perr != nil {
+		return perr
+	}
+	return err
+}
+
+func (r *redis) Pop(cid string, n int) ([]*Message, error) {
+	ms := r.ram.pop(cid, n)
+	if len(ms) == 0 {
+		return nil, nil
+	}
+	fields := make([]string, 0, len(ms))
+	for _, m := range ms {
+		fields = append(fields, strconv.FormatUint(m.Seq, 10))
+	}
+	_, err := r.db.HDel(context.Background(), r.prefix+cid, fields...).Result()
+	return ms, err
+}
+
+func (r *redis) Len(cid string) int {
+	return r.ram.Len(cid)
+}
+
+func (r *redis) Clear(cid string) error {
+	r.ram.clear(cid)
+	_, err := r.db.Del(context.Background(), r.prefix+cid).Result()
+	return err
+}
+
+func (r *redis) Close() error {
+	return r.db.Close()
+}
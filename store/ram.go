@@ -1,6 +1,13 @@
 package store
 
-import "github.com/laomar/gomq/store/topic"
+import (
+	"github.com/laomar/gomq/store/auth"
+	"github.com/laomar/gomq/store/inflight"
+	"github.com/laomar/gomq/store/queue"
+	"github.com/laomar/gomq/store/retain"
+	"github.com/laomar/gomq/store/session"
+	"github.com/laomar/gomq/store/topic"
+)
 
 type ram struct {
 }
@@ -12,3 +19,23 @@ func NewRam() Store {
 func (r *ram) NewTopicStore() (topic.Store, error) {
 	return topic.NewRam(), nil
 }
+
+func (r *ram) NewRetainStore() (retain.Store, error) {
+	return retain.NewRam(), nil
+}
+
+func (r *ram) NewSessionStore() (session.Store, error) {
+	return session.NewRam(), nil
+}
+
+func (r *ram) NewQueueStore() (queue.Store, error) {
+	return queue.NewRam(), nil
+}
+
+func (r *ram) NewInflightStore() (inflight.Store, error) {
+	return inflight.NewRam(), nil
+}
+
+func (r *ram) NewAuthStore() (auth.Store, error) {
+	return auth.NewRam(), nil
+}
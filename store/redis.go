@@ -3,6 +3,11 @@ package store
 import (
 	"context"
 	"github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/store/auth"
+	"github.com/laomar/gomq/store/inflight"
+	"github.com/laomar/gomq/store/queue"
+	"github.com/laomar/gomq/store/retain"
+	"github.com/laomar/gomq/store/session"
 	"github.com/laomar/gomq/store/topic"
 	goredis "github.com/redis/go-redis/v9"
 	"time"
@@ -30,3 +35,23 @@ func NewRedis() (Store, error) {
 func (r *redis) NewTopicStore() (topic.Store, error) {
 	return topic.NewRedis(r.db), nil
 }
+
+func (r *redis) NewRetainStore() (retain.Store, error) {
+	return retain.NewRedis(r.db), nil
+}
+
+func (r *redis) NewSessionStore() (session.Store, error) {
+	return session.NewRedis(r.db), nil
+}
+
+func (r *redis) NewQueueStore() (queue.Store, error) {
+	return queue.NewRedis(r.db), nil
+}
+
+func (r *redis) NewInflightStore() (inflight.Store, error) {
+	return inflight.NewRedis(r.db), nil
+}
+
+func (r *redis) NewAuthStore() (auth.Store, error) {
+	return auth.NewRedis(r.db), nil
+}
@@ -0,0 +1,60 @@
+package retain
+
+import (
+	"encoding/json"
+	"github.com/laomar/gomq/config"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+type disk struct {
+	ram *Ram
+	db  *leveldb.DB
+}
+
+func NewDisk() (*disk, error) {
+	db, err := leveldb.OpenFile(config.Cfg.DataDir+"/retain", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &disk{
+		db:  db,
+		ram: NewRam(),
+	}, nil
+}
+
+func (d *disk) Init() error {
+	iter := d.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		m := new(Message)
+		if err := json.Unmarshal(iter.Value(), m); err != nil {
+			return err
+		}
+		_ = d.ram.Set(m)
+	}
+	return iter.Error()
+}
+
+func (d *disk) Set(m *Message) error {
+	jm, _ := json.Marshal(m)
+	if err := d.db.Put([]byte(prefix+m.TopicName), jm, nil); err != nil {
+		return err
+	}
+	return d.ram.Set(m)
+}
+
+func (d *disk) Del(name string) error {
+	if err := d.db.Delete([]byte(prefix+name), nil); err != nil {
+		return err
+	}
+	return d.ram.Del(name)
+}
+
+func (d *disk) Match(filter string) []*Message {
+	return d.ram.Match(filter)
+}
+
+func (d *disk) Close() error {
+	return d.db.Close()
+}
@@ -0,0 +1,51 @@
+package retain
+
+import (
+	"github.com/laomar/gomq/store/topic"
+	"sync"
+)
+
+type Ram struct {
+	sync.RWMutex
+	msgs map[string]*Message
+}
+
+func NewRam() *Ram {
+	return &Ram{
+		msgs: make(map[string]*Message),
+	}
+}
+
+func (r *Ram) Init() error {
+	return nil
+}
+
+func (r *Ram) Set(m *Message) error {
+	defer r.Unlock()
+	r.Lock()
+	r.msgs[m.TopicName] = m
+	return nil
+}
+
+func (r *Ram) Del(name string) error {
+	defer r.Unlock()
+	r.Lock()
+	delete(r.msgs, name)
+	return nil
+}
+
+func (r *Ram) Match(filter string) []*Message {
+	defer r.RUnlock()
+	r.RLock()
+	ms := make([]*Message, 0)
+	for name, m := range r.msgs {
+		if topic.IsMatch(filter, name) {
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
+
+func (r *Ram) Close() error {
+	return nil
+}
@@ -0,0 +1,65 @@
+package retain
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/laomar/gomq/config"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+type redis struct {
+	ram *Ram
+	db  goredis.UniversalClient
+	key string
+}
+
+func NewRedis(db goredis.UniversalClient) *redis {
+	redis := &redis{
+		ram: NewRam(),
+		db:  db,
+		key: prefix,
+	}
+	nodeName := config.Cfg.NodeName
+	if nodeName != "" {
+		redis.key += nodeName
+	}
+	return redis
+}
+
+func (r *redis) Init() error {
+	msgs, err := r.db.HGetAll(context.Background(), r.key).Result()
+	if err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		rm := new(Message)
+		if err := json.Unmarshal([]byte(m), rm); err != nil {
+			return err
+		}
+		_ = r.ram.Set(rm)
+	}
+	return nil
+}
+
+func (r *redis) Set(m *Message) error {
+	jm, _ := json.Marshal(m)
+	if _, err := r.db.HSet(context.Background(), r.key, m.TopicName, string(jm)).Result(); err != nil {
+		return err
+	}
+	return r.ram.Set(m)
+}
+
+func (r *redis) Del(name string) error {
+	if _, err := r.db.HDel(context.Background(), r.key, name).Result(); err != nil {
+		return err
+	}
+	return r.ram.Del(name)
+}
+
+func (r *redis) Match(filter string) []*Message {
+	return r.ram.Match(filter)
+}
+
+func (r *redis) Close() error {
+	return r.db.Close()
+}
@@ -0,0 +1,22 @@
+package retain
+
+import (
+	"github.com/laomar/gomq/pkg/packets"
+)
+
+const prefix = "retain:"
+
+// Retained message, expire at is unix milliseconds the message expires at, 0 means never,
+// publish is embedded so that the stored retained messages keep their format
+type Message struct {
+	*packets.Publish
+	ExpireAt int64 `json:",omitempty"`
+}
+
+type Store interface {
+	Init() error
+	Set(*Message) error
+	Del(string) error
+	Match(string) []*Message
+	Close() error
+}
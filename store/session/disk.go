@@ -0,0 +1,49 @@
+package session
+
+import (
+	"encoding/json"
+	"github.com/laomar/gomq/config"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+type disk struct {
+	db *leveldb.DB
+}
+
+func NewDisk() (*disk, error) {
+	db, err := leveldb.OpenFile(config.Cfg.DataDir+"/session", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &disk{
+		db: db,
+	}, nil
+}
+
+func (d *disk) Set(s *Session) error {
+	js, _ := json.Marshal(s)
+	return d.db.Put([]byte(prefix+s.ClientID), js, nil)
+}
+
+func (d *disk) Del(cid string) error {
+	return d.db.Delete([]byte(prefix+cid), nil)
+}
+
+func (d *disk) All() ([]*Session, error) {
+	iter := d.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+	ss := make([]*Session, 0)
+	for iter.Next() {
+		s := new(Session)
+		if err := json.Unmarshal(iter.Value(), s); err != nil {
+			return nil, err
+		}
+		ss = append(ss, s)
+	}
+	return ss, iter.Error()
+}
+
+func (d *disk) Close() error {
+	return d.db.Close()
+}
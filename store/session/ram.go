@@ -0,0 +1,44 @@
+package session
+
+import (
+	"sync"
+)
+
+type Ram struct {
+	sync.RWMutex
+	sessions map[string]*Session
+}
+
+func NewRam() *Ram {
+	return &Ram{
+		sessions: make(map[string]*Session),
+	}
+}
+
+func (r *Ram) Set(s *Session) error {
+	defer r.Unlock()
+	r.Lock()
+	r.sessions[s.ClientID] = s
+	return nil
+}
+
+func (r *Ram) Del(cid string) error {
+	defer r.Unlock()
+	r.Lock()
+	delete(r.sessions, cid)
+	return nil
+}
+
+func (r *Ram) All() ([]*Session, error) {
+	defer r.RUnlock()
+	r.RLock()
+	ss := make([]*Session, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		ss = append(ss, s)
+	}
+	return ss, nil
+}
+
+func (r *Ram) Close() error {
+	return nil
+}
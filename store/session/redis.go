@@ -0,0 +1,56 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/laomar/gomq/config"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+type redis struct {
+	db  goredis.UniversalClient
+	key string
+}
+
+func NewRedis(db goredis.UniversalClient) *redis {
+	redis := &redis{
+		db:  db,
+		key: prefix,
+	}
+	nodeName := config.Cfg.NodeName
+	if nodeName != "" {
+		redis.key += nodeName
+	}
+	return redis
+}
+
+func (r *redis) Set(s *Session) error {
+	js, _ := json.Marshal(s)
+	_, err := r.db.HSet(context.Background(), r.key, s.ClientID, string(js)).Result()
+	return err
+}
+
+func (r *redis) Del(cid string) error {
+	_, err := r.db.HDel(context.Background(), r.key, cid).Result()
+	return err
+}
+
+func (r *redis) All() ([]*Session, error) {
+	vals, err := r.db.HGetAll(context.Background(), r.key).Result()
+	if err != nil {
+		return nil, err
+	}
+	ss := make([]*Session, 0, len(vals))
+	for _, v := range vals {
+		s := new(Session)
+		if err := json.Unmarshal([]byte(v), s); err != nil {
+			return nil, err
+		}
+		ss = append(ss, s)
+	}
+	return ss, nil
+}
+
+func (r *redis) Close() error {
+	return r.db.Close()
+}
@@ -0,0 +1,17 @@
+package session
+
+const prefix = "session:"
+
+type Session struct {
+	ClientID       string
+	ExpiryInterval uint32
+	DisconnectedAt int64
+	Reason         byte // reason code of the last disconnect
+}
+
+type Store interface {
+	Set(*Session) error
+	Del(string) error
+	All() ([]*Session, error)
+	Close() error
+}
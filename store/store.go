@@ -2,11 +2,21 @@ package store
 
 import (
 	"github.com/laomar/gomq/config"
+	"github.com/laomar/gomq/store/auth"
+	"github.com/laomar/gomq/store/inflight"
+	"github.com/laomar/gomq/store/queue"
+	"github.com/laomar/gomq/store/retain"
+	"github.com/laomar/gomq/store/session"
 	"github.com/laomar/gomq/store/topic"
 )
 
 type Store interface {
 	NewTopicStore() (topic.Store, error)
+	NewRetainStore() (retain.Store, error)
+	NewSessionStore() (session.Store, error)
+	NewQueueStore() (queue.Store, error)
+	NewInflightStore() (inflight.Store, error)
+	NewAuthStore() (auth.Store, error)
 }
 
 func NewStore() (Store, error) {
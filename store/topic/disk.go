@@ -29,7 +29,7 @@ func (d *disk) Init(cids ...string) error {
 		return nil
 	}
 	for _, cid := range cids {
-		iter := d.db.NewIterator(util.BytesPrefix([]byte(prefix+cid)), nil)
+		iter := d.db.NewIterator(util.BytesPrefix([]byte(prefix+cid+":")), nil)
 		for iter.Next() {
 			sub := new(packets.Subscription)
 			if err := json.Unmarshal(iter.Value(), &sub); err != nil {
@@ -54,30 +54,42 @@ func (d *disk) Subscribe(cid string, subs ...*packets.Subscription) (bool, error
 	return d.ram.Subscribe(cid, subs...)
 }
 
-func (d *disk) Unsubscribe(cid string, topics ...string) error {
+func (d *disk) Unsubscribe(cid string, topics ...string) (bool, error) {
 	batch := new(leveldb.Batch)
 	for _, topic := range topics {
 		batch.Delete([]byte(prefix + cid + ":" + topic))
 	}
 	if err := d.db.Write(batch, nil); err != nil {
-		return err
+		return false, err
 	}
 	return d.ram.Unsubscribe(cid, topics...)
 }
 
-func (d *disk) UnsubscribeAll(cid string) error {
-	iter := d.db.NewIterator(util.BytesPrefix([]byte(prefix+cid)), nil)
+func (d *disk) Subscribed(topic string) bool {
+	return d.ram.Subscribed(topic)
+}
+
+func (d *disk) UnsubscribeAll(cid string) ([]string, error) {
+	iter := d.db.NewIterator(util.BytesPrefix([]byte(prefix+cid+":")), nil)
 	batch := new(leveldb.Batch)
 	for iter.Next() {
 		batch.Delete(iter.Key())
 	}
 	iter.Release()
 	if err := d.db.Write(batch, nil); err != nil {
-		return err
+		return nil, err
 	}
 	return d.ram.UnsubscribeAll(cid)
 }
 
+func (d *disk) Match(topic string) map[string][]*packets.Subscription {
+	return d.ram.Match(topic)
+}
+
+func (d *disk) MatchShare(topic string) map[string][]*packets.Subscription {
+	return d.ram.MatchShare(topic)
+}
+
 func (d *disk) Close() error {
 	return d.db.Close()
 }
@@ -37,25 +37,59 @@ func (r *Ram) Subscribe(cid string, subs ...*packets.Subscription) (bool, error)
 	return isExist, nil
 }
 
-func (r *Ram) Unsubscribe(cid string, topics ...string) error {
+// Unsubscribe topic filters, returns false when none of them was subscribed
+func (r *Ram) Unsubscribe(cid string, topics ...string) (bool, error) {
 	defer r.Unlock()
 	r.Lock()
+	isExist := false
 	for _, topic := range topics {
-		if strings.HasPrefix(topic, "$share") {
-			r.shareTopic.unsubscribe(cid, topic)
+		if isShare(topic) {
+			isExist = r.shareTopic.unsubscribe(cid, topic) || isExist
 		} else {
-			r.userTopic.unsubscribe(cid, topic)
+			isExist = r.userTopic.unsubscribe(cid, topic) || isExist
 		}
 	}
-	return nil
+	return isExist, nil
+}
+
+// Subscribed reports whether the topic filter has any subscriber
+func (r *Ram) Subscribed(topic string) bool {
+	defer r.RUnlock()
+	r.RLock()
+	t := r.userTopic
+	if isShare(topic) {
+		t = r.shareTopic
+	}
+	node := t.find(topic)
+	return node != nil && len(node.subs) > 0
 }
 
-func (r *Ram) UnsubscribeAll(cid string) error {
+// UnsubscribeAll topic filters of client, returns the topic filters unsubscribed
+func (r *Ram) UnsubscribeAll(cid string) ([]string, error) {
 	defer r.Unlock()
 	r.Lock()
-	r.userTopic.unsubscribeAll(cid)
-	r.shareTopic.unsubscribeAll(cid)
-	return nil
+	topics := r.userTopic.unsubscribeAll(cid, nil)
+	return r.shareTopic.unsubscribeAll(cid, topics), nil
+}
+
+func (r *Ram) Match(topic string) map[string][]*packets.Subscription {
+	defer r.RUnlock()
+	r.RLock()
+	subs := make(map[string][]*packets.Subscription)
+	r.userTopic.matchRoot(strings.Split(topic, "/"), subs)
+	return subs
+}
+
+// MatchShare matches the shared subscriptions of all groups
+func (r *Ram) MatchShare(topic string) map[string][]*packets.Subscription {
+	defer r.RUnlock()
+	r.RLock()
+	subs := make(map[string][]*packets.Subscription)
+	names := strings.Split(topic, "/")
+	for _, group := range r.shareTopic.children {
+		group.matchRoot(names, subs)
+	}
+	return subs
 }
 
 func (r *Ram) Close() error {
@@ -59,20 +59,32 @@ func (r *redis) Subscribe(cid string, subs ...*packets.Subscription) (bool, erro
 	return r.ram.Subscribe(cid, subs...)
 }
 
-func (r *redis) Unsubscribe(cid string, topics ...string) error {
+func (r *redis) Unsubscribe(cid string, topics ...string) (bool, error) {
 	if _, err := r.db.HDel(context.Background(), r.prefix+cid, topics...).Result(); err != nil {
-		return err
+		return false, err
 	}
 	return r.ram.Unsubscribe(cid, topics...)
 }
 
-func (r *redis) UnsubscribeAll(cid string) error {
+func (r *redis) Subscribed(topic string) bool {
+	return r.ram.Subscribed(topic)
+}
+
+func (r *redis) UnsubscribeAll(cid string) ([]string, error) {
 	if _, err := r.db.Del(context.Background(), r.prefix+cid).Result(); err != nil {
-		return err
+		return nil, err
 	}
 	return r.ram.UnsubscribeAll(cid)
 }
 
+func (r *redis) Match(topic string) map[string][]*packets.Subscription {
+	return r.ram.Match(topic)
+}
+
+func (r *redis) MatchShare(topic string) map[string][]*packets.Subscription {
+	return r.ram.MatchShare(topic)
+}
+
 func (r *redis) Close() error {
 	return r.db.Close()
 }
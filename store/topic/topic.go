@@ -2,6 +2,7 @@ package topic
 
 import (
 	"github.com/laomar/gomq/pkg/packets"
+	"strings"
 )
 
 const prefix = "topic:"
@@ -9,7 +10,50 @@ const prefix = "topic:"
 type Store interface {
 	Init(...string) error
 	Subscribe(string, ...*packets.Subscription) (bool, error)
-	Unsubscribe(string, ...string) error
-	UnsubscribeAll(string) error
+	Unsubscribe(string, ...string) (bool, error)
+	UnsubscribeAll(string) ([]string, error)
+	Subscribed(string) bool
+	Match(string) map[string][]*packets.Subscription
+	MatchShare(string) map[string][]*packets.Subscription
 	Close() error
 }
+
+// IsMatch reports whether the topic name matches the topic filter
+func IsMatch(filter, name string) bool {
+	fs := strings.Split(filter, "/")
+	ns := strings.Split(name, "/")
+	if strings.HasPrefix(ns[0], "$") && (fs[0] == "+" || fs[0] == "#") {
+		return false
+	}
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ns) || (f != "+" && f != ns[i]) {
+			return false
+		}
+	}
+	return len(fs) == len(ns)
+}
+
+// IsValidFilter reports whether the topic filter is valid, wildcards must take a whole level and '#' must be the last level
+func IsValidFilter(filter string) bool {
+	if filter == "" {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return false
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether the topic filter is a shared subscription
+func isShare(topic string) bool {
+	return strings.HasPrefix(topic, "$share/")
+}
@@ -23,12 +23,8 @@ func newTrie(name string) *trie {
 
 func (t *trie) subscribe(cid string, sub *packets.Subscription) bool {
 	isExist := true
-	names := strings.Split(sub.Topic, "/")
 	node := t
-	for _, name := range names {
-		if name == "$share" {
-			continue
-		}
+	for _, name := range levels(sub.Topic) {
 		if _, ok := node.children[name]; !ok {
 			child := newTrie(name)
 			child.parent = node
@@ -44,6 +40,40 @@ func (t *trie) subscribe(cid string, sub *packets.Subscription) bool {
 	return isExist
 }
 
+// Match topic name, '+' matches one level, '#' matches the remaining levels
+func (t *trie) match(names []string, subs map[string][]*packets.Subscription) {
+	if c, ok := t.children["#"]; ok {
+		c.collect(subs)
+	}
+	if len(names) == 0 {
+		t.collect(subs)
+		return
+	}
+	if c, ok := t.children["+"]; ok {
+		c.match(names[1:], subs)
+	}
+	if c, ok := t.children[names[0]]; ok {
+		c.match(names[1:], subs)
+	}
+}
+
+// Match topic name from root, topics beginning with '$' are not matched by wildcards at the first level
+func (t *trie) matchRoot(names []string, subs map[string][]*packets.Subscription) {
+	if strings.HasPrefix(names[0], "$") {
+		if c, ok := t.children[names[0]]; ok {
+			c.match(names[1:], subs)
+		}
+		return
+	}
+	t.match(names, subs)
+}
+
+func (t *trie) collect(subs map[string][]*packets.Subscription) {
+	for cid, sub := range t.subs {
+		subs[cid] = append(subs[cid], sub)
+	}
+}
+
 func (t *trie) print(pname ...string) {
 	if t.name != "user" && t.name != "share" {
 		pname = append(pname, t.name)
@@ -61,20 +91,36 @@ func (t *trie) print(pname ...string) {
 	}
 }
 
-func (t *trie) unsubscribe(cid string, topic string) {
+// Levels of topic filter, $share of shared subscription is skipped
+func levels(topic string) []string {
 	names := strings.Split(topic, "/")
+	if isShare(topic) {
+		names = names[1:]
+	}
+	return names
+}
+
+// Node of topic filter, nil when not found
+func (t *trie) find(topic string) *trie {
 	node := t
-	for _, name := range names {
-		if name == "$share" {
-			continue
-		}
+	for _, name := range levels(topic) {
 		if _, ok := node.children[name]; !ok {
-			return
+			return nil
 		}
 		node = node.children[name]
 	}
+	return node
+}
+
+// Unsubscribe topic filter, returns false when the client has not subscribed it
+func (t *trie) unsubscribe(cid string, topic string) bool {
+	node := t.find(topic)
+	if node == nil || node.subs[cid] == nil {
+		return false
+	}
 	delete(node.subs, cid)
 	node.delete()
+	return true
 }
 
 func (t *trie) delete() {
@@ -85,13 +131,18 @@ func (t *trie) delete() {
 	t.parent.delete()
 }
 
-func (t *trie) unsubscribeAll(cid string) {
+// Unsubscribe all topic filters of client, the topic filters are appended to topics
+func (t *trie) unsubscribeAll(cid string, topics []string) []string {
 	for _, c := range t.children {
-		delete(c.subs, cid)
+		if sub, ok := c.subs[cid]; ok {
+			topics = append(topics, sub.Topic)
+			delete(c.subs, cid)
+		}
 		if len(c.children) > 0 {
-			c.unsubscribeAll(cid)
+			topics = c.unsubscribeAll(cid, topics)
 		} else {
 			c.delete()
 		}
 	}
+	return topics
 }
@@ -0,0 +1,117 @@
+package topic
+
+import (
+	"github.com/laomar/gomq/pkg/packets"
+	"sort"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	r := NewRam()
+	filters := map[string]string{
+		"exact":  "a/b/c",
+		"plus":   "a/+/c",
+		"hash":   "a/#",
+		"all":    "#",
+		"first":  "+/b/c",
+		"level":  "+",
+		"sys":    "$SYS/#",
+		"sysone": "$SYS/+",
+		"empty":  "a//c",
+		"share":  "$share/g/a/b/c",
+	}
+	for cid, filter := range filters {
+		sub := &packets.Subscription{Topic: filter}
+		if cid == "share" {
+			sub.ShareName = "g"
+		}
+		r.Subscribe(cid, sub)
+	}
+	tests := []struct {
+		topic string
+		want  []string
+	}{
+		{"a/b/c", []string{"all", "exact", "first", "hash", "plus"}},
+		{"a/x/c", []string{"all", "hash", "plus"}},
+		{"a", []string{"all", "hash", "level"}},
+		{"a/b", []string{"all", "hash"}},
+		{"a//c", []string{"all", "empty", "hash", "plus"}},
+		{"b", []string{"all", "level"}},
+		{"x/b/c", []string{"all", "first"}},
+		{"$SYS/a", []string{"sys", "sysone"}},
+		{"$SYS/a/b", []string{"sys"}},
+		{"$SYS", []string{"sys"}},
+		{"$other/b/c", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			var got []string
+			for cid := range r.Match(tt.topic) {
+				got = append(got, cid)
+			}
+			sort.Strings(got)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchShare(t *testing.T) {
+	r := NewRam()
+	r.Subscribe("a", &packets.Subscription{Topic: "$share/g1/a/+", ShareName: "g1"})
+	r.Subscribe("b", &packets.Subscription{Topic: "$share/g2/#", ShareName: "g2"})
+	r.Subscribe("c", &packets.Subscription{Topic: "$share/g2/$SYS/#", ShareName: "g2"})
+	r.Subscribe("d", &packets.Subscription{Topic: "a/b"})
+	tests := []struct {
+		topic string
+		want  map[string]string
+	}{
+		{"a/b", map[string]string{"a": "$share/g1/a/+", "b": "$share/g2/#"}},
+		{"b", map[string]string{"b": "$share/g2/#"}},
+		{"$SYS/a", map[string]string{"c": "$share/g2/$SYS/#"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			got := r.MatchShare(tt.topic)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d members, want %v", len(got), tt.want)
+			}
+			for cid, filter := range tt.want {
+				if subs := got[cid]; len(subs) != 1 || subs[0].Topic != filter {
+					t.Fatalf("member %s got %v, want %s", cid, subs, filter)
+				}
+			}
+		})
+	}
+}
+
+func TestIsMatch(t *testing.T) {
+	tests := []struct {
+		filter string
+		name   string
+		want   bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"+/+", "a/b", true},
+		{"+", "a/b", false},
+		{"#", "$SYS/a", false},
+		{"+/a", "$SYS/a", false},
+		{"$SYS/#", "$SYS/a", true},
+		{"$SYS/+", "$SYS/a", true},
+	}
+	for _, tt := range tests {
+		if got := IsMatch(tt.filter, tt.name); got != tt.want {
+			t.Errorf("IsMatch(%q, %q) = %v, want %v", tt.filter, tt.name, got, tt.want)
+		}
+	}
+}